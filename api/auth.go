@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"mini-twitter/auth"
+	"mini-twitter/domain/credentials"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type LoginRequest struct {
+	UserId   string `json:"userId"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	UserId string `json:"userId"`
+	Token  string `json:"token"`
+}
+
+// newTokens signs tokens with AUTH_SECRET. The server does not start without
+// it.
+func newTokens() *auth.Tokens {
+	secret := []byte(os.Getenv("AUTH_SECRET"))
+	if len(secret) == 0 {
+		log.Fatal("AUTH_SECRET is not set")
+	}
+	return &auth.Tokens{Secret: secret, TTL: 24 * time.Hour}
+}
+
+func (h *HTTPHandler) Register(rw http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || len(req.Password) < 8 {
+		response := ErrorResponse{"Password must be at least 8 characters long"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	hash, err := auth.HashPassword(req.Password)
+	if err == nil {
+		c := credentials.Credentials{UserId: primitive.NewObjectID().Hex(), PasswordHash: hash}
+		err = h.credentials.AddCredentials(r.Context(), &c)
+		req.UserId = c.UserId
+	}
+	var token string
+	if err == nil {
+		token, err = h.tokens.Issue(req.UserId)
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	ans, _ := json.Marshal(LoginResponse{UserId: req.UserId, Token: token})
+	_, _ = rw.Write(ans)
+}
+
+func (h *HTTPHandler) Login(rw http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	c, err := h.credentials.GetCredentials(r.Context(), req.UserId)
+	if err != nil || !auth.CheckPassword(c.PasswordHash, req.Password) {
+		response := ErrorResponse{"Invalid user id or password"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	token, err := h.tokens.Issue(c.UserId)
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	ans, _ := json.Marshal(LoginResponse{UserId: c.UserId, Token: token})
+	_, _ = rw.Write(ans)
+}
+
+// authMiddleware resolves the caller from the "Authorization: Bearer" header
+// and puts the user ID into the request context. Requests without the header
+// pass through anonymously; handlers decide whether they need a caller. In dev
+// mode the raw User-Id header is trusted as well.
+func authMiddleware(tokens *auth.Tokens, devMode bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header != "" {
+				token := strings.TrimPrefix(header, "Bearer ")
+				userId, err := tokens.Parse(token)
+				if token == header || err != nil {
+					response := ErrorResponse{"Invalid token"}
+					rw.Header().Set("Content-Type", "application/json")
+					rw.WriteHeader(http.StatusUnauthorized)
+					rawResponse, _ := json.Marshal(response)
+					_, _ = rw.Write(rawResponse)
+					return
+				}
+				r = r.WithContext(auth.WithUserId(r.Context(), userId))
+			} else if devMode && r.Header.Get("User-Id") != "" {
+				r = r.WithContext(auth.WithUserId(r.Context(), r.Header.Get("User-Id")))
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/storage"
+	"net/http"
+)
+
+func (h *HTTPHandler) Block(rw http.ResponseWriter, r *http.Request) {
+	h.changeUserList(rw, r, h.storage.Block)
+}
+
+func (h *HTTPHandler) Unblock(rw http.ResponseWriter, r *http.Request) {
+	h.changeUserList(rw, r, h.storage.Unblock)
+}
+
+func (h *HTTPHandler) GetBlocked(rw http.ResponseWriter, r *http.Request) {
+	h.getUserList(rw, r, h.storage.GetBlocked)
+}
+
+func (h *HTTPHandler) Mute(rw http.ResponseWriter, r *http.Request) {
+	h.changeUserList(rw, r, h.storage.Mute)
+}
+
+func (h *HTTPHandler) Unmute(rw http.ResponseWriter, r *http.Request) {
+	h.changeUserList(rw, r, h.storage.Unmute)
+}
+
+func (h *HTTPHandler) GetMuted(rw http.ResponseWriter, r *http.Request) {
+	h.getUserList(rw, r, h.storage.GetMuted)
+}
+
+// changeUserList adds the user of the path to a list of the requesting user,
+// or removes it from the list.
+func (h *HTTPHandler) changeUserList(rw http.ResponseWriter, r *http.Request, change func(ctx context.Context, userId string, otherId string) error) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	otherId := mux.Vars(r)["userId"]
+	if !validateUserId(otherId) {
+		response := ErrorResponse{"Invalid user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	err := change(r.Context(), userId, otherId)
+	if err == storage.ErrInvalidBlock {
+		response := ErrorResponse{"Cannot block or mute yourself"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrUserNotFound {
+		response := ErrorResponse{"User not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPHandler) getUserList(rw http.ResponseWriter, r *http.Request, get func(ctx context.Context, userId string) ([]string, error)) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	users, err := get(r.Context(), userId)
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	ans, _ := json.Marshal(map[string]any{"users": users})
+	_, _ = rw.Write(ans)
+}
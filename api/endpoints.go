@@ -2,14 +2,19 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
-	"github.com/RichardKnop/machinery/v1"
+	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"mini-twitter/auth"
+	"mini-twitter/dispatcher"
 	"mini-twitter/domain/post"
 	"mini-twitter/storage"
+	"mini-twitter/stream"
 	"net/http"
 	"os"
 	"regexp"
@@ -20,30 +25,93 @@ import (
 
 type PostsByUserId map[string]any
 
-func MakeServer(s *machinery.Server) *http.Server {
+func MakeServer(d dispatcher.FeedDispatcher, b stream.Broker) *http.Server {
 	r := mux.NewRouter()
 
-	handler := NewHTTPHandler(s)
+	handler := NewHTTPHandler(d, b)
+	r.Use(authMiddleware(handler.tokens, os.Getenv("AUTH_MODE") == "DEV"))
+
+	r.HandleFunc("/api/v1/auth/register", handler.Register).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/auth/login", handler.Login).Methods(http.MethodPost)
 
 	r.HandleFunc("/api/v1/posts/{postId}", handler.GetPostById).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/posts", handler.CreatePost).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/posts/{postId}/replies", handler.GetReplies).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/posts/{postId}/thread", handler.GetThread).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/posts/{postId}/repost", handler.Repost).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/posts/{postId}/like", handler.Like).Methods(http.MethodPut)
+	r.HandleFunc("/api/v1/posts/{postId}/like", handler.Unlike).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/posts/{postId}/likes", handler.GetLikes).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users/{userId}/likes", handler.GetLikedPosts).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/tags/{tag}/posts", handler.GetPostsByTag).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/users/{userId}/posts", handler.GetPostsByUserId).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users", handler.CreateUser).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/users", handler.GetUserByHandle).Methods(http.MethodGet).Queries("handle", "{handle}")
+	r.HandleFunc("/api/v1/users/{userId}", handler.GetUserById).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users/{userId}", handler.ModifyUser).Methods(http.MethodPatch)
 	r.HandleFunc("/api/v1/posts/{postId}", handler.ModifyPost).Methods(http.MethodPatch)
+	r.HandleFunc("/api/v1/posts/{postId}", handler.DeletePost).Methods(http.MethodDelete)
 	r.HandleFunc("/api/v1/users/{userId}/subscribe", handler.Subscribe).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/users/{userId}/subscribe", handler.Unsubscribe).Methods(http.MethodDelete)
 	r.HandleFunc("/api/v1/subscriptions", handler.GetSubscriptions).Methods(http.MethodGet)
 	r.HandleFunc("/api/v1/subscribers", handler.GetSubscribers).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/follow-requests", handler.GetFollowRequests).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/follow-requests/{userId}/approve", handler.ApproveFollowRequest).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/follow-requests/{userId}/reject", handler.RejectFollowRequest).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/users/{userId}/block", handler.Block).Methods(http.MethodPut)
+	r.HandleFunc("/api/v1/users/{userId}/block", handler.Unblock).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/blocks", handler.GetBlocked).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/users/{userId}/mute", handler.Mute).Methods(http.MethodPut)
+	r.HandleFunc("/api/v1/users/{userId}/mute", handler.Unmute).Methods(http.MethodDelete)
+	r.HandleFunc("/api/v1/mutes", handler.GetMuted).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/filters", handler.GetFilters).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/filters", handler.AddFilter).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/filters/{filterId}", handler.DeleteFilter).Methods(http.MethodDelete)
 	r.HandleFunc("/api/v1/feed", handler.GetFeed).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/feed/stream", handler.StreamFeed).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/ws", handler.Gateway).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/notifications", handler.GetNotifications).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/notifications/unread-count", handler.GetUnreadCount).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/notifications/read", handler.MarkAllRead).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/notifications/{notificationId}/read", handler.MarkRead).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/admin/deadletters", handler.GetDeadLetters).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/admin/deadletters/{deadLetterId}/replay", handler.ReplayDeadLetter).Methods(http.MethodPost)
 
 	srv := &http.Server{
 		Handler:      r,
 		Addr:         fmt.Sprintf("0.0.0.0:%s", os.Getenv("SERVER_PORT")),
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
+		ConnContext:  withConn,
 	}
 	return srv
 }
 
-func NewHTTPHandler(s *machinery.Server) *HTTPHandler {
+// newPageTokens signs page tokens with PAGE_TOKEN_SECRET. The server does not
+// start without it, since every server has to accept the tokens of the others.
+func newPageTokens() *storage.PageTokens {
+	secret := []byte(os.Getenv("PAGE_TOKEN_SECRET"))
+	if len(secret) == 0 {
+		log.Fatal("PAGE_TOKEN_SECRET is not set")
+	}
+	return &storage.PageTokens{Secret: secret}
+}
+
+func NewHTTPHandler(d dispatcher.FeedDispatcher, b stream.Broker) *HTTPHandler {
+	h := &HTTPHandler{tokens: newTokens(), dispatcher: d, broker: b}
+	pages := newPageTokens()
+	if os.Getenv("STORAGE_TYPE") == "MEMORY" {
+		h.storageType = "MEMORY"
+		notifications := storage.NewInMemoryNotificationStorage(pages)
+		notifications.Broker = b
+		h.notifications = notifications
+		memory := storage.NewInMemoryStorage(pages)
+		memory.Notifications = h.notifications
+		memory.Broker = b
+		h.storage = memory
+		h.credentials = storage.NewInMemoryCredentialStorage()
+		return h
+	}
 	ctx := context.Background()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_URL")))
 	if err != nil {
@@ -53,24 +121,79 @@ func NewHTTPHandler(s *machinery.Server) *HTTPHandler {
 	feed := client.Database(os.Getenv("MONGO_DBNAME")).Collection("feed")
 	subscriptions := client.Database(os.Getenv("MONGO_DBNAME")).Collection("subscriptions")
 	subscribers := client.Database(os.Getenv("MONGO_DBNAME")).Collection("subscribers")
-	return &HTTPHandler{storage: &storage.MongoStorage{
-		Posts:         posts,
-		Feed:          feed,
-		Subscriptions: subscriptions,
-		Subscribers:   subscribers,
-		Server:        s,
-	}}
+	users := client.Database(os.Getenv("MONGO_DBNAME")).Collection("users")
+	likes := client.Database(os.Getenv("MONGO_DBNAME")).Collection("likes")
+	followRequests := client.Database(os.Getenv("MONGO_DBNAME")).Collection("followRequests")
+	blocks := client.Database(os.Getenv("MONGO_DBNAME")).Collection("blocks")
+	mutes := client.Database(os.Getenv("MONGO_DBNAME")).Collection("mutes")
+	filters := client.Database(os.Getenv("MONGO_DBNAME")).Collection("filters")
+	outbox := client.Database(os.Getenv("MONGO_DBNAME")).Collection("outbox")
+	relay := storage.NewOutboxRelay(outbox, d)
+	go relay.Run(ctx)
+	mongoStorage := &storage.MongoStorage{
+		Posts:          posts,
+		Feed:           feed,
+		Subscriptions:  subscriptions,
+		Subscribers:    subscribers,
+		Users:          users,
+		Likes:          likes,
+		FollowRequests: followRequests,
+		Blocks:         blocks,
+		Mutes:          mutes,
+		Filters:        filters,
+		Outbox:         outbox,
+		Client:         client,
+		Relay:          relay,
+		Pages:          pages,
+	}
+	err = mongoStorage.EnsureIndexes(ctx)
+	if err != nil {
+		log.Printf("failed to create indexes: %v", err)
+	}
+	h.deadLetters = &storage.MongoDeadLetterStorage{
+		DeadLetters: client.Database(os.Getenv("MONGO_DBNAME")).Collection("deadletters"),
+		Pages:       pages,
+	}
+	h.credentials = &storage.MongoCredentialStorage{
+		Credentials: client.Database(os.Getenv("MONGO_DBNAME")).Collection("credentials"),
+	}
+	notifications := &storage.MongoNotificationStorage{
+		Notifications: client.Database(os.Getenv("MONGO_DBNAME")).Collection("notifications"),
+		Pages:         pages,
+	}
+	err = notifications.EnsureIndexes(ctx)
+	if err != nil {
+		log.Printf("failed to create indexes: %v", err)
+	}
+	h.notifications = notifications
+	if os.Getenv("CACHE_TYPE") == "REDIS" {
+		h.storageType = "CACHED"
+		h.storage = &storage.CachedStorage{
+			Client:          redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_URL")}),
+			InternalStorage: mongoStorage,
+		}
+		return h
+	}
+	h.storageType = "MONGO"
+	h.storage = mongoStorage
+	return h
 }
 
 type HTTPHandler struct {
-	storageType string
-	storage     storage.Storage
+	storageType   string
+	storage       storage.Storage
+	deadLetters   storage.DeadLetterStorage
+	credentials   storage.CredentialStorage
+	notifications storage.NotificationStorage
+	dispatcher    dispatcher.FeedDispatcher
+	broker        stream.Broker
+	tokens        *auth.Tokens
 }
 
 func (h *HTTPHandler) CreatePost(rw http.ResponseWriter, r *http.Request) {
 	var newPost post.Post
 	_ = json.NewDecoder(r.Body).Decode(&newPost)
-	userId := r.Header.Get("User-Id")
+	userId := auth.UserId(r.Context())
 	if !validateUserId(userId) {
 		response := ErrorResponse{"Invalid or empty user id"}
 		rw.Header().Set("Content-Type", "application/json")
@@ -79,7 +202,55 @@ func (h *HTTPHandler) CreatePost(rw http.ResponseWriter, r *http.Request) {
 		_, _ = rw.Write(rawResponse)
 		return
 	}
-	h.storage.AddPost(r.Context(), userId, &newPost)
+	if !post.ValidVisibility(newPost.Visibility) {
+		response := ErrorResponse{"Invalid visibility"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	err := h.storage.AddPost(r.Context(), userId, &newPost)
+	if err == storage.ErrUserNotFound {
+		response := ErrorResponse{"User not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrParentNotFound {
+		response := ErrorResponse{"Replied post not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrPostNotFound {
+		response := ErrorResponse{"Quoted post not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrForbiddenAccess {
+		response := ErrorResponse{"Post cannot be quoted"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
 	rw.Header().Set("Content-Type", "application/json")
 	ans, _ := json.Marshal(newPost)
 	_, _ = rw.Write(ans)
@@ -87,7 +258,7 @@ func (h *HTTPHandler) CreatePost(rw http.ResponseWriter, r *http.Request) {
 
 func (h *HTTPHandler) GetPostById(rw http.ResponseWriter, r *http.Request) {
 	postId := strings.Split(r.URL.Path, "/")[4]
-	p, err := h.storage.GetPostById(r.Context(), postId)
+	p, err := h.storage.GetPostById(r.Context(), auth.UserId(r.Context()), postId)
 	if err != nil {
 		response := ErrorResponse{"Post not found"}
 		rw.Header().Set("Content-Type", "application/json")
@@ -103,22 +274,28 @@ func (h *HTTPHandler) GetPostById(rw http.ResponseWriter, r *http.Request) {
 
 func (h *HTTPHandler) GetPostsByUserId(rw http.ResponseWriter, r *http.Request) {
 	userId := strings.Split(r.URL.Path, "/")[4]
-	pageToken := r.URL.Query().Get("page")
-	sizeStr := r.URL.Query().Get("size")
-	var size = storage.DEFAULT
-	var err error
-	if sizeStr != "" {
-		size, err = strconv.Atoi(sizeStr)
-		if err != nil || size <= 0 || size > 100 {
-			response := ErrorResponse{"Invalid size"}
-			rw.Header().Set("Content-Type", "application/json")
-			rw.WriteHeader(http.StatusBadRequest)
-			rawResponse, _ := json.Marshal(response)
-			_, _ = rw.Write(rawResponse)
-			return
-		}
+	before, since := timelineCursors(r)
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	page, err := h.storage.GetPostsByUserId(r.Context(), auth.UserId(r.Context()), userId, before, since, size)
+	if err == storage.ErrBlocked {
+		response := ErrorResponse{"Blocked"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrForbiddenAccess {
+		response := ErrorResponse{"Protected account"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
 	}
-	arr, nextToken, err := h.storage.GetPostsByUserId(r.Context(), userId, pageToken, size)
 	if err != nil {
 		response := ErrorResponse{"Invalid token"}
 		rw.Header().Set("Content-Type", "application/json")
@@ -127,11 +304,7 @@ func (h *HTTPHandler) GetPostsByUserId(rw http.ResponseWriter, r *http.Request)
 		_, _ = rw.Write(rawResponse)
 		return
 	}
-	ans := make(PostsByUserId)
-	if nextToken != "" {
-		ans["nextPage"] = nextToken
-	}
-	ans["posts"] = arr
+	ans := timelineResponse(page, since)
 	ansStr, _ := json.Marshal(ans)
 	rw.Header().Set("Content-Type", "application/json")
 	_, _ = rw.Write(ansStr)
@@ -140,7 +313,7 @@ func (h *HTTPHandler) GetPostsByUserId(rw http.ResponseWriter, r *http.Request)
 func (h *HTTPHandler) ModifyPost(rw http.ResponseWriter, r *http.Request) {
 	var newPost post.Post
 	_ = json.NewDecoder(r.Body).Decode(&newPost)
-	userId := r.Header.Get("User-Id")
+	userId := auth.UserId(r.Context())
 	if !validateUserId(userId) {
 		response := ErrorResponse{"Invalid or empty user id"}
 		rw.Header().Set("Content-Type", "application/json")
@@ -172,10 +345,77 @@ func (h *HTTPHandler) ModifyPost(rw http.ResponseWriter, r *http.Request) {
 	_, _ = rw.Write(ans)
 }
 
+func (h *HTTPHandler) DeletePost(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	postId := strings.Split(r.URL.Path, "/")[4]
+	err := h.storage.DeletePost(r.Context(), userId, postId)
+	if err == storage.ErrForbiddenAccess {
+		response := ErrorResponse{"Forbidden access"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrPostNotFound {
+		response := ErrorResponse{"Not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
 func (h *HTTPHandler) Subscribe(rw http.ResponseWriter, r *http.Request) {
-	subscriber := r.Header.Get("User-Id")
+	subscriber := auth.UserId(r.Context())
+	if !validateUserId(subscriber) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
 	subscribee := strings.Split(r.URL.Path, "/")[4]
 	err := h.storage.Subscribe(r.Context(), subscribee, subscriber)
+	if err == storage.ErrFollowRequested {
+		rw.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err == storage.ErrBlocked {
+		response := ErrorResponse{"Blocked"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrUserNotFound {
+		response := ErrorResponse{"User not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
 	if err != nil {
 		response := ErrorResponse{"Bad request"}
 		rw.Header().Set("Content-Type", "application/json")
@@ -186,9 +426,19 @@ func (h *HTTPHandler) Subscribe(rw http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *HTTPHandler) GetSubscribers(rw http.ResponseWriter, r *http.Request) {
-	user := r.Header.Get("User-Id")
-	if user == "" {
+func (h *HTTPHandler) Unsubscribe(rw http.ResponseWriter, r *http.Request) {
+	subscriber := auth.UserId(r.Context())
+	if !validateUserId(subscriber) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	subscribee := strings.Split(r.URL.Path, "/")[4]
+	err := h.storage.Unsubscribe(r.Context(), subscribee, subscriber)
+	if err != nil {
 		response := ErrorResponse{"Bad request"}
 		rw.Header().Set("Content-Type", "application/json")
 		rw.WriteHeader(http.StatusBadRequest)
@@ -196,6 +446,18 @@ func (h *HTTPHandler) GetSubscribers(rw http.ResponseWriter, r *http.Request) {
 		_, _ = rw.Write(rawResponse)
 		return
 	}
+}
+
+func (h *HTTPHandler) GetSubscribers(rw http.ResponseWriter, r *http.Request) {
+	user := auth.UserId(r.Context())
+	if !validateUserId(user) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
 	subscribers, _ := h.storage.GetSubscribers(r.Context(), user)
 	rw.Header().Set("Content-Type", "application/json")
 	a := make(map[string]any)
@@ -205,11 +467,11 @@ func (h *HTTPHandler) GetSubscribers(rw http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTPHandler) GetSubscriptions(rw http.ResponseWriter, r *http.Request) {
-	user := r.Header.Get("User-Id")
-	if user == "" {
-		response := ErrorResponse{"Bad request"}
+	user := auth.UserId(r.Context())
+	if !validateUserId(user) {
+		response := ErrorResponse{"Invalid or empty user id"}
 		rw.Header().Set("Content-Type", "application/json")
-		rw.WriteHeader(http.StatusBadRequest)
+		rw.WriteHeader(http.StatusUnauthorized)
 		rawResponse, _ := json.Marshal(response)
 		_, _ = rw.Write(rawResponse)
 		return
@@ -223,31 +485,96 @@ func (h *HTTPHandler) GetSubscriptions(rw http.ResponseWriter, r *http.Request)
 }
 
 func (h *HTTPHandler) GetFeed(rw http.ResponseWriter, r *http.Request) {
-	userId := r.Header.Get("User-Id")
-	if userId == "" {
-		response := ErrorResponse{"Invalid user id"}
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	before, since := timelineCursors(r)
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	page, err := h.storage.GetFeed(r.Context(), userId, before, since, size)
+	if err != nil {
+		response := ErrorResponse{"Invalid token"}
 		rw.Header().Set("Content-Type", "application/json")
 		rw.WriteHeader(http.StatusBadRequest)
 		rawResponse, _ := json.Marshal(response)
 		_, _ = rw.Write(rawResponse)
 		return
 	}
-	pageToken := r.URL.Query().Get("page")
+	ans := timelineResponse(page, since)
+	ansStr, _ := json.Marshal(ans)
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
+
+// parseSize reads the size query parameter. If it is invalid, it writes the
+// error response and returns false.
+func parseSize(rw http.ResponseWriter, r *http.Request) (int, bool) {
 	sizeStr := r.URL.Query().Get("size")
-	var size = storage.DEFAULT
-	var err error
-	if sizeStr != "" {
-		size, err = strconv.Atoi(sizeStr)
-		if err != nil || size <= 0 || size > 100 {
-			response := ErrorResponse{"Invalid size"}
-			rw.Header().Set("Content-Type", "application/json")
-			rw.WriteHeader(http.StatusBadRequest)
-			rawResponse, _ := json.Marshal(response)
-			_, _ = rw.Write(rawResponse)
-			return
-		}
+	if sizeStr == "" {
+		return storage.DEFAULT, true
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size <= 0 || size > 100 {
+		response := ErrorResponse{"Invalid size"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return 0, false
 	}
-	arr, nextToken, err := h.storage.GetFeed(r.Context(), userId, pageToken, size)
+	return size, true
+}
+
+// timelineCursors reads the before and since cursors of a timeline. The page
+// parameter is the older name of before.
+func timelineCursors(r *http.Request) (string, string) {
+	before := r.URL.Query().Get("before")
+	if before == "" {
+		before = r.URL.Query().Get("page")
+	}
+	return before, r.URL.Query().Get("since")
+}
+
+// timelineResponse keeps nextPage for the clients that only walk backwards.
+func timelineResponse(page *storage.Page, since string) PostsByUserId {
+	ans := make(PostsByUserId)
+	if since == "" && page.HasMore {
+		ans["nextPage"] = page.Before
+	}
+	if page.Before != "" {
+		ans["before"] = page.Before
+	}
+	if page.Since != "" {
+		ans["since"] = page.Since
+	}
+	ans["hasMore"] = page.HasMore
+	ans["posts"] = page.Posts
+	return ans
+}
+
+func (h *HTTPHandler) GetDeadLetters(rw http.ResponseWriter, r *http.Request) {
+	if !validateAdminToken(r.Header.Get("Admin-Token")) || h.deadLetters == nil {
+		response := ErrorResponse{"Forbidden access"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	pageToken := r.URL.Query().Get("page")
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	arr, nextToken, err := h.deadLetters.GetDeadLetters(r.Context(), pageToken, size)
 	if err != nil {
 		response := ErrorResponse{"Invalid token"}
 		rw.Header().Set("Content-Type", "application/json")
@@ -256,17 +583,73 @@ func (h *HTTPHandler) GetFeed(rw http.ResponseWriter, r *http.Request) {
 		_, _ = rw.Write(rawResponse)
 		return
 	}
-	ans := make(PostsByUserId)
+	ans := make(map[string]any)
 	if nextToken != "" {
 		ans["nextPage"] = nextToken
 	}
-	ans["posts"] = arr
+	ans["deadLetters"] = arr
 	ansStr, _ := json.Marshal(ans)
 	rw.Header().Set("Content-Type", "application/json")
 	_, _ = rw.Write(ansStr)
 }
 
+func (h *HTTPHandler) ReplayDeadLetter(rw http.ResponseWriter, r *http.Request) {
+	if !validateAdminToken(r.Header.Get("Admin-Token")) || h.deadLetters == nil {
+		response := ErrorResponse{"Forbidden access"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	deadLetterId := mux.Vars(r)["deadLetterId"]
+	d, err := h.deadLetters.GetDeadLetterById(r.Context(), deadLetterId)
+	if err == storage.ErrDeadLetterNotFound {
+		response := ErrorResponse{"Not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	var e dispatcher.Event
+	if err == nil {
+		e, err = dispatcher.EventFromArgs(d.Name, d.Args)
+	}
+	if err == nil {
+		err = h.dispatcher.Dispatch(r.Context(), e)
+	}
+	if err == nil {
+		err = h.deadLetters.RemoveDeadLetter(r.Context(), deadLetterId)
+	}
+	if err != nil {
+		response := ErrorResponse{err.Error()}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// validateAdminToken checks the token against ADMIN_TOKEN. The admin API is
+// disabled when the variable is not set.
+func validateAdminToken(token string) bool {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
 func validateUserId(userId string) bool {
 	r := regexp.MustCompile("^[0-9a-f]+$")
 	return r.MatchString(userId)
 }
+
+func containsString(arr []string, s string) bool {
+	for _, elem := range arr {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/domain/filter"
+	"mini-twitter/storage"
+	"mini-twitter/utils"
+	"net/http"
+	"time"
+)
+
+// filterRequest is a new feed filter. ExpiresIn is its lifetime in seconds,
+// zero keeps it until it is deleted.
+type filterRequest struct {
+	Kind      string `json:"kind"`
+	Value     string `json:"value"`
+	ExpiresIn int    `json:"expiresIn"`
+}
+
+func (h *HTTPHandler) GetFilters(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	filters, err := h.storage.GetFilters(r.Context(), userId)
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	ans, _ := json.Marshal(map[string]any{"filters": filters})
+	_, _ = rw.Write(ans)
+}
+
+func (h *HTTPHandler) AddFilter(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	var req filterRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if req.ExpiresIn < 0 {
+		response := ErrorResponse{"Invalid expiry"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	f := &filter.Filter{Kind: req.Kind, Value: req.Value}
+	if f.Kind == "" {
+		f.Kind = filter.KindWord
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := time.Now().UTC().Truncate(time.Second).Add(time.Duration(req.ExpiresIn) * time.Second)
+		f.ExpiresAt = &expiresAt
+	}
+	err := utils.NormalizeFilter(f)
+	if err == utils.ErrFilterTooComplex {
+		response := ErrorResponse{"Filter is too complex"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Invalid filter"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	err = h.storage.AddFilter(r.Context(), userId, f)
+	if err == storage.ErrTooManyFilters {
+		response := ErrorResponse{"Too many filters"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusConflict)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	ans, _ := json.Marshal(f)
+	_, _ = rw.Write(ans)
+}
+
+func (h *HTTPHandler) DeleteFilter(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	err := h.storage.DeleteFilter(r.Context(), userId, mux.Vars(r)["filterId"])
+	if err == storage.ErrFilterNotFound {
+		response := ErrorResponse{"Filter not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/storage"
+	"net/http"
+)
+
+func (h *HTTPHandler) GetFollowRequests(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	requests, err := h.storage.GetFollowRequests(r.Context(), userId)
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	ans, _ := json.Marshal(map[string]any{"users": requests})
+	_, _ = rw.Write(ans)
+}
+
+func (h *HTTPHandler) ApproveFollowRequest(rw http.ResponseWriter, r *http.Request) {
+	h.answerFollowRequest(rw, r, h.storage.ApproveFollowRequest)
+}
+
+func (h *HTTPHandler) RejectFollowRequest(rw http.ResponseWriter, r *http.Request) {
+	h.answerFollowRequest(rw, r, h.storage.RejectFollowRequest)
+}
+
+func (h *HTTPHandler) answerFollowRequest(rw http.ResponseWriter, r *http.Request, answer func(ctx context.Context, userId string, subscriber string) error) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	err := answer(r.Context(), userId, mux.Vars(r)["userId"])
+	if err == storage.ErrFollowRequestNotFound {
+		response := ErrorResponse{"Follow request not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"log"
+	"mini-twitter/auth"
+	"mini-twitter/storage"
+	"mini-twitter/stream"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	gatewayWriteWait = 10 * time.Second
+	// gatewayPongWait is how long the gateway waits for any message or pong
+	// from the client before it closes the connection.
+	gatewayPongWait   = 60 * time.Second
+	gatewayPingPeriod = gatewayPongWait * 9 / 10
+	// gatewayPresencePeriod is how often an online event is repeated while the
+	// user stays connected. There is no offline event, a user is offline once
+	// the online events stop.
+	gatewayPresencePeriod = 30 * time.Second
+	gatewayMaxMessageSize = 4096
+	// gatewayTypingInterval is how often a connection may send the typing
+	// event of a conversation, and gatewayMaxTypingConversations is how many
+	// conversations it may type in within that interval.
+	gatewayTypingInterval         = 3 * time.Second
+	gatewayMaxTypingConversations = 5
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// gatewayRequest is a message from the client. Action is one of subscribe,
+// unsubscribe and typing.
+type gatewayRequest struct {
+	Action         string `json:"action"`
+	Channel        string `json:"channel"`
+	ConversationId string `json:"conversationId"`
+}
+
+// gatewayMessage is a message to the client. Type is either the type of a
+// stream message or subscribed, unsubscribed or error.
+type gatewayMessage struct {
+	Channel string          `json:"channel,omitempty"`
+	Type    string          `json:"type"`
+	Id      string          `json:"id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// typingLimiter remembers when a connection last sent the typing event of
+// each conversation. It is only used by the reading loop of the connection.
+type typingLimiter map[string]time.Time
+
+// allow reports whether the typing event of the conversation may be sent now.
+func (l typingLimiter) allow(conversationId string, now time.Time) bool {
+	for id, last := range l {
+		if now.Sub(last) >= gatewayTypingInterval {
+			delete(l, id)
+		}
+	}
+	if _, ok := l[conversationId]; ok || len(l) >= gatewayMaxTypingConversations {
+		return false
+	}
+	l[conversationId] = now
+	return true
+}
+
+// Gateway serves a WebSocket connection that multiplexes the channels feed,
+// notifications, typing and presence:{userId}. The client picks the channels with
+// subscribe and unsubscribe requests. Messages are read from the broker by a
+// single writer per connection, and a connection that does not keep up is
+// dropped by the broker and closed, so it cannot slow down anybody else.
+func (h *HTTPHandler) Gateway(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ws, err := upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response.
+		return
+	}
+	defer ws.Close()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	sub, err := h.broker.Subscribe(ctx)
+	if err != nil {
+		log.Printf("gateway: failed to subscribe: %v", err)
+		return
+	}
+	defer sub.Close()
+	screen, err := h.newFeedScreen(ctx, userId)
+	if err != nil {
+		log.Printf("gateway: failed to read the feed settings of user %s: %v", userId, err)
+		return
+	}
+
+	h.publishPresence(ctx, userId, stream.TypeOnline)
+
+	replies := make(chan gatewayMessage, 16)
+	go h.gatewayWrite(ctx, ws, userId, sub, screen, replies)
+	typing := make(typingLimiter)
+
+	ws.SetReadLimit(gatewayMaxMessageSize)
+	_ = ws.SetReadDeadline(time.Now().Add(gatewayPongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(gatewayPongWait))
+	})
+	for {
+		_, raw, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = ws.SetReadDeadline(time.Now().Add(gatewayPongWait))
+		var req gatewayRequest
+		reply := gatewayError("Invalid request")
+		if json.Unmarshal(raw, &req) == nil {
+			reply = h.handleGatewayRequest(ctx, userId, sub, typing, replies, &req)
+		}
+		if reply == nil {
+			continue
+		}
+		select {
+		case replies <- *reply:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *HTTPHandler) handleGatewayRequest(ctx context.Context, userId string, sub stream.Subscription, typing typingLimiter, replies chan<- gatewayMessage, req *gatewayRequest) *gatewayMessage {
+	switch req.Action {
+	case "subscribe", "unsubscribe":
+		topic, ok := channelTopic(userId, req.Channel)
+		if !ok {
+			return gatewayError("Unknown channel")
+		}
+		presenceOf := strings.TrimPrefix(req.Channel, "presence:")
+		if req.Action == "subscribe" && presenceOf != req.Channel {
+			allowed, err := h.presenceAllowed(ctx, userId, presenceOf)
+			if err != nil {
+				return gatewayError("Internal error")
+			}
+			if !allowed {
+				return gatewayError("Forbidden channel")
+			}
+		}
+		change := sub.Subscribe
+		if req.Action == "unsubscribe" {
+			change = sub.Unsubscribe
+		}
+		err := change(ctx, topic)
+		if err != nil {
+			return gatewayError("Internal error")
+		}
+		return &gatewayMessage{Channel: req.Channel, Type: req.Action + "d"}
+	case "typing":
+		if req.ConversationId == "" {
+			return gatewayError("Empty conversation id")
+		}
+		if !typing.allow(req.ConversationId, time.Now()) {
+			return nil
+		}
+		// The reading loop does not wait for the storage, the errors are
+		// written to the client when they come.
+		go func(conversationId string) {
+			reply := h.publishTyping(ctx, userId, conversationId)
+			if reply == nil {
+				return
+			}
+			select {
+			case replies <- *reply:
+			case <-ctx.Done():
+			}
+		}(req.ConversationId)
+		return nil
+	}
+	return gatewayError("Unknown action")
+}
+
+// gatewayWrite is the only goroutine that writes to the connection. It closes
+// the connection when it stops, which also stops the reading loop.
+func (h *HTTPHandler) gatewayWrite(ctx context.Context, ws *websocket.Conn, userId string, sub stream.Subscription, screen *feedScreen, replies <-chan gatewayMessage) {
+	defer ws.Close()
+	ping := time.NewTicker(gatewayPingPeriod)
+	defer ping.Stop()
+	online := time.NewTicker(gatewayPresencePeriod)
+	defer online.Stop()
+	for {
+		var err error
+		select {
+		case m, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			if m.Topic == stream.FeedTopic(userId) && screen.hidesMessage(m) {
+				continue
+			}
+			_ = ws.SetWriteDeadline(time.Now().Add(gatewayWriteWait))
+			err = ws.WriteJSON(gatewayMessage{Channel: topicChannel(userId, m.Topic), Type: m.Type, Id: m.Id, Data: m.Data})
+		case reply := <-replies:
+			_ = ws.SetWriteDeadline(time.Now().Add(gatewayWriteWait))
+			err = ws.WriteJSON(reply)
+		case <-ping.C:
+			err = ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(gatewayWriteWait))
+		case <-online.C:
+			h.publishPresence(ctx, userId, stream.TypeOnline)
+			fresh, err := h.newFeedScreen(ctx, userId)
+			if err == nil {
+				screen = fresh
+			}
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (h *HTTPHandler) publishPresence(ctx context.Context, userId string, typ string) {
+	data := map[string]string{"userId": userId}
+	err := h.broker.Publish(ctx, stream.NewMessage(typ, "", data), stream.PresenceTopic(userId))
+	if err != nil {
+		log.Printf("gateway: failed to publish %s of user %s: %v", typ, userId, err)
+	}
+}
+
+// publishTyping sends the typing event to the other members of the
+// conversation who may follow the presence of the user, see presenceAllowed.
+// The user and their blocks are read once for all the members.
+func (h *HTTPHandler) publishTyping(ctx context.Context, userId string, conversationId string) *gatewayMessage {
+	members, err := h.storage.GetConversationMembers(ctx, userId, conversationId)
+	if err == storage.ErrPostNotFound {
+		return gatewayError("Conversation not found")
+	}
+	if err != nil {
+		return gatewayError("Internal error")
+	}
+	u, err := h.storage.GetUserById(ctx, userId)
+	if err == storage.ErrUserNotFound {
+		// Nobody may follow the presence of a user without a profile.
+		return nil
+	}
+	if err != nil {
+		return gatewayError("Internal error")
+	}
+	blocked, err := h.storage.GetBlockedWith(ctx, userId)
+	if err != nil {
+		return gatewayError("Internal error")
+	}
+	var subscribers []string
+	if u.Protected {
+		subscribers, err = h.storage.GetSubscribers(ctx, userId)
+		if err != nil {
+			return gatewayError("Internal error")
+		}
+	}
+	topics := make([]string, 0, len(members))
+	for _, memberId := range members {
+		if memberId == userId || containsString(blocked, memberId) {
+			continue
+		}
+		if u.Protected && !containsString(subscribers, memberId) {
+			continue
+		}
+		topics = append(topics, stream.TypingTopic(memberId))
+	}
+	if len(topics) == 0 {
+		return nil
+	}
+	data := map[string]string{"userId": userId, "conversationId": conversationId}
+	err = h.broker.Publish(ctx, stream.NewMessage(stream.TypeTyping, "", data), topics...)
+	if err != nil {
+		log.Printf("gateway: failed to publish typing of user %s: %v", userId, err)
+	}
+	return nil
+}
+
+// presenceAllowed reports whether viewerId may follow the presence of userId:
+// neither of them blocked the other, and the account of userId is public or
+// followed by viewerId.
+func (h *HTTPHandler) presenceAllowed(ctx context.Context, viewerId string, userId string) (bool, error) {
+	if viewerId == userId {
+		return true, nil
+	}
+	u, err := h.storage.GetUserById(ctx, userId)
+	if err == storage.ErrUserNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	blocked, err := h.storage.GetBlocked(ctx, userId)
+	if err != nil || containsString(blocked, viewerId) {
+		return false, err
+	}
+	blocked, err = h.storage.GetBlocked(ctx, viewerId)
+	if err != nil || containsString(blocked, userId) {
+		return false, err
+	}
+	if !u.Protected {
+		return true, nil
+	}
+	subscriptions, err := h.storage.GetSubscriptions(ctx, viewerId)
+	if err != nil {
+		return false, err
+	}
+	return containsString(subscriptions, userId), nil
+}
+
+// channelTopic maps a channel of the client to a broker topic. The feed,
+// notifications and typing channels always belong to the connected user.
+func channelTopic(userId string, channel string) (string, bool) {
+	switch channel {
+	case "feed":
+		return stream.FeedTopic(userId), true
+	case "notifications":
+		return stream.NotificationsTopic(userId), true
+	case "typing":
+		return stream.TypingTopic(userId), true
+	}
+	presenceOf := strings.TrimPrefix(channel, "presence:")
+	if presenceOf != channel && validateUserId(presenceOf) {
+		return stream.PresenceTopic(presenceOf), true
+	}
+	return "", false
+}
+
+func topicChannel(userId string, topic string) string {
+	switch topic {
+	case stream.FeedTopic(userId):
+		return "feed"
+	case stream.NotificationsTopic(userId):
+		return "notifications"
+	case stream.TypingTopic(userId):
+		return "typing"
+	}
+	return topic
+}
+
+func gatewayError(err string) *gatewayMessage {
+	data, _ := json.Marshal(ErrorResponse{err})
+	return &gatewayMessage{Type: "error", Data: data}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/storage"
+	"net/http"
+)
+
+func (h *HTTPHandler) Like(rw http.ResponseWriter, r *http.Request) {
+	h.changeLike(rw, r, h.storage.Like)
+}
+
+func (h *HTTPHandler) Unlike(rw http.ResponseWriter, r *http.Request) {
+	h.changeLike(rw, r, h.storage.Unlike)
+}
+
+func (h *HTTPHandler) changeLike(rw http.ResponseWriter, r *http.Request, change func(ctx context.Context, userId string, postId string) error) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	err := change(r.Context(), userId, mux.Vars(r)["postId"])
+	if err == storage.ErrUserNotFound || err == storage.ErrPostNotFound {
+		response := ErrorResponse{err.Error()}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPHandler) GetLikes(rw http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	_, err := h.storage.GetPostById(r.Context(), auth.UserId(r.Context()), postId)
+	if err != nil {
+		response := ErrorResponse{"Post not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	userIds, nextToken, err := h.storage.GetLikes(r.Context(), postId, r.URL.Query().Get("page"), size)
+	if err != nil {
+		response := ErrorResponse{"Invalid token"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ans := make(map[string]any)
+	if nextToken != "" {
+		ans["nextPage"] = nextToken
+	}
+	ans["users"] = userIds
+	ansStr, _ := json.Marshal(ans)
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
+
+func (h *HTTPHandler) GetLikedPosts(rw http.ResponseWriter, r *http.Request) {
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	arr, nextToken, err := h.storage.GetLikedPosts(r.Context(), auth.UserId(r.Context()), mux.Vars(r)["userId"], r.URL.Query().Get("page"), size)
+	if err == storage.ErrBlocked {
+		response := ErrorResponse{"Blocked"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err == storage.ErrForbiddenAccess {
+		response := ErrorResponse{"Protected account"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Invalid token"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ans := make(PostsByUserId)
+	if nextToken != "" {
+		ans["nextPage"] = nextToken
+	}
+	ans["posts"] = arr
+	ansStr, _ := json.Marshal(ans)
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/storage"
+	"net/http"
+)
+
+func (h *HTTPHandler) GetNotifications(rw http.ResponseWriter, r *http.Request) {
+	userId, ok := h.notificationsUser(rw, r)
+	if !ok {
+		return
+	}
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	arr, nextToken, err := h.notifications.GetNotifications(r.Context(), userId, r.URL.Query().Get("page"), size)
+	if err != nil {
+		response := ErrorResponse{"Invalid token"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ans := make(map[string]any)
+	if nextToken != "" {
+		ans["nextPage"] = nextToken
+	}
+	ans["notifications"] = arr
+	ansStr, _ := json.Marshal(ans)
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
+
+func (h *HTTPHandler) GetUnreadCount(rw http.ResponseWriter, r *http.Request) {
+	userId, ok := h.notificationsUser(rw, r)
+	if !ok {
+		return
+	}
+	count, err := h.notifications.GetUnreadCount(r.Context(), userId)
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ansStr, _ := json.Marshal(map[string]int{"count": count})
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
+
+func (h *HTTPHandler) MarkRead(rw http.ResponseWriter, r *http.Request) {
+	userId, ok := h.notificationsUser(rw, r)
+	if !ok {
+		return
+	}
+	err := h.notifications.MarkRead(r.Context(), userId, mux.Vars(r)["notificationId"])
+	if err == storage.ErrNotificationNotFound {
+		response := ErrorResponse{err.Error()}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *HTTPHandler) MarkAllRead(rw http.ResponseWriter, r *http.Request) {
+	userId, ok := h.notificationsUser(rw, r)
+	if !ok {
+		return
+	}
+	err := h.notifications.MarkAllRead(r.Context(), userId)
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// notificationsUser returns the authenticated user. Notifications are private,
+// so without one it writes the error response and returns false.
+func (h *HTTPHandler) notificationsUser(rw http.ResponseWriter, r *http.Request) (string, bool) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return "", false
+	}
+	return userId, true
+}
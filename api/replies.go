@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/storage"
+	"net/http"
+)
+
+func (h *HTTPHandler) GetReplies(rw http.ResponseWriter, r *http.Request) {
+	postId := mux.Vars(r)["postId"]
+	_, err := h.storage.GetPostById(r.Context(), auth.UserId(r.Context()), postId)
+	if err != nil {
+		response := ErrorResponse{"Post not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	pageToken := r.URL.Query().Get("page")
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	arr, nextToken, err := h.storage.GetReplies(r.Context(), auth.UserId(r.Context()), postId, pageToken, size)
+	if err != nil {
+		response := ErrorResponse{"Invalid token"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ans := make(PostsByUserId)
+	if nextToken != "" {
+		ans["nextPage"] = nextToken
+	}
+	ans["posts"] = arr
+	ansStr, _ := json.Marshal(ans)
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
+
+// GetThread returns the chain of posts from the root of the conversation down
+// to the requested post.
+func (h *HTTPHandler) GetThread(rw http.ResponseWriter, r *http.Request) {
+	thread, err := h.storage.GetThread(r.Context(), auth.UserId(r.Context()), mux.Vars(r)["postId"])
+	if err == storage.ErrPostNotFound {
+		response := ErrorResponse{"Post not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ans := make(map[string]any)
+	ans["posts"] = thread
+	ansStr, _ := json.Marshal(ans)
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
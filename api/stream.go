@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mini-twitter/auth"
+	"mini-twitter/domain/feed"
+	"mini-twitter/domain/post"
+	"mini-twitter/storage"
+	"mini-twitter/stream"
+	"mini-twitter/utils"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// streamResumeSize is the number of missed entries read at a time when a
+	// client resumes the stream.
+	streamResumeSize = 100
+	// streamHeartbeat keeps idle connections from being closed by proxies.
+	streamHeartbeat = 15 * time.Second
+	// streamWriteTimeout replaces the server write timeout for each write to
+	// the stream, so that clients that stopped reading are disconnected.
+	streamWriteTimeout = 15 * time.Second
+)
+
+type connKey struct{}
+
+// feedScreen hides the entries of a feed stream that GetFeed leaves out of the
+// pages of the feed. It is reloaded periodically, so that changes to the mutes
+// and filters apply to open streams.
+type feedScreen struct {
+	muted  []string
+	filter *utils.FeedFilter
+}
+
+func (h *HTTPHandler) newFeedScreen(ctx context.Context, userId string) (*feedScreen, error) {
+	muted, err := h.storage.GetMuted(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := h.storage.GetFilters(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &feedScreen{muted: muted, filter: utils.NewFeedFilter(filters)}, nil
+}
+
+func (fs *feedScreen) hides(p *post.Post) bool {
+	return storage.MutedIn(p, fs.muted) || fs.filter.Hides(p)
+}
+
+// hidesMessage reports whether a message of the feed topic carries a post the
+// screen hides. Other messages are never hidden.
+func (fs *feedScreen) hidesMessage(m stream.Message) bool {
+	if m.Type != stream.TypeCreate && m.Type != stream.TypeModify {
+		return false
+	}
+	var p post.Post
+	if json.Unmarshal(m.Data, &p) != nil {
+		return false
+	}
+	return fs.hides(&p)
+}
+
+// withConn makes the connection of a request available to handlers that have
+// to change its deadlines.
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// StreamFeed pushes new entries of the user's feed as Server-Sent Events. New
+// entries carry their oid as the event id, so a client that reconnects with
+// Last-Event-ID first receives the entries it missed. Modified entries are
+// sent without an id and are not replayed.
+func (h *HTTPHandler) StreamFeed(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		response := ErrorResponse{"Streaming is not supported"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ctx := r.Context()
+	// Subscribe before reading the missed entries, so that nothing written in
+	// between is lost. Entries that arrive both ways are sent once.
+	sub, err := h.broker.Subscribe(ctx, stream.FeedTopic(userId))
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	defer sub.Close()
+	screen, err := h.newFeedScreen(ctx, userId)
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	lastEventId := r.Header.Get("Last-Event-ID")
+	missed := make([]feed.Entry, 0)
+	if lastEventId != "" {
+		missed, err = h.storage.GetFeedSince(ctx, userId, lastEventId, streamResumeSize)
+		if err == storage.ErrParseToken {
+			response := ErrorResponse{"Invalid Last-Event-ID"}
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusBadRequest)
+			rawResponse, _ := json.Marshal(response)
+			_, _ = rw.Write(rawResponse)
+			return
+		}
+		if err != nil {
+			response := ErrorResponse{"Internal error"}
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusInternalServerError)
+			rawResponse, _ := json.Marshal(response)
+			_, _ = rw.Write(rawResponse)
+			return
+		}
+	}
+
+	conn, _ := ctx.Value(connKey{}).(net.Conn)
+	if conn != nil {
+		// The server read timeout would otherwise cancel the request.
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+	write := func(format string, args ...any) bool {
+		if conn != nil {
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		}
+		_, err := fmt.Fprintf(rw, format, args...)
+		flusher.Flush()
+		return err == nil
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	if !write(": connected\n\n") {
+		return
+	}
+
+	sent := make(map[string]bool)
+	for len(missed) != 0 {
+		for _, e := range missed {
+			lastEventId = e.Oid
+			if screen.hides(e.Post) {
+				continue
+			}
+			data, _ := json.Marshal(e.Post)
+			if !write("id: %s\nevent: %s\ndata: %s\n\n", e.Oid, stream.TypeCreate, data) {
+				return
+			}
+			sent[e.Oid] = true
+		}
+		if len(missed) < streamResumeSize {
+			break
+		}
+		missed, err = h.storage.GetFeedSince(ctx, userId, lastEventId, streamResumeSize)
+		if err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case m, ok := <-sub.Messages():
+			if !ok {
+				// The client fell behind. It reconnects and resumes.
+				return
+			}
+			if screen.hidesMessage(m) {
+				continue
+			}
+			if m.Type == stream.TypeCreate {
+				if sent[m.Id] {
+					continue
+				}
+				ok = write("id: %s\nevent: %s\ndata: %s\n\n", m.Id, m.Type, m.Data)
+			} else {
+				ok = write("event: %s\ndata: %s\n\n", m.Type, m.Data)
+			}
+			if !ok {
+				return
+			}
+		case <-heartbeat.C:
+			fresh, err := h.newFeedScreen(ctx, userId)
+			if err == nil {
+				screen = fresh
+			}
+			if !write(": heartbeat\n\n") {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/utils"
+	"net/http"
+)
+
+func (h *HTTPHandler) GetPostsByTag(rw http.ResponseWriter, r *http.Request) {
+	size, ok := parseSize(rw, r)
+	if !ok {
+		return
+	}
+	tag := utils.NormalizeTag(mux.Vars(r)["tag"])
+	arr, nextToken, err := h.storage.GetPostsByTag(r.Context(), auth.UserId(r.Context()), tag, r.URL.Query().Get("page"), size)
+	if err != nil {
+		response := ErrorResponse{"Invalid token"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	ans := make(PostsByUserId)
+	if nextToken != "" {
+		ans["nextPage"] = nextToken
+	}
+	ans["posts"] = arr
+	ansStr, _ := json.Marshal(ans)
+	rw.Header().Set("Content-Type", "application/json")
+	_, _ = rw.Write(ansStr)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mini-twitter/auth"
+	"mini-twitter/domain/user"
+	"mini-twitter/storage"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+func (h *HTTPHandler) CreateUser(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	var newUser user.User
+	_ = json.NewDecoder(r.Body).Decode(&newUser)
+	newUser.Id = userId
+	newUser.Handle = strings.ToLower(newUser.Handle)
+	if !validateHandle(newUser.Handle) {
+		response := ErrorResponse{"Invalid handle"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	err := h.storage.AddUser(r.Context(), &newUser)
+	if err == storage.ErrUserExists || err == storage.ErrHandleTaken {
+		response := ErrorResponse{err.Error()}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusConflict)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	ans, _ := json.Marshal(newUser)
+	_, _ = rw.Write(ans)
+}
+
+func (h *HTTPHandler) GetUserById(rw http.ResponseWriter, r *http.Request) {
+	u, err := h.storage.GetUserById(r.Context(), mux.Vars(r)["userId"])
+	h.writeUser(rw, u, err)
+}
+
+func (h *HTTPHandler) GetUserByHandle(rw http.ResponseWriter, r *http.Request) {
+	u, err := h.storage.GetUserByHandle(r.Context(), strings.ToLower(r.URL.Query().Get("handle")))
+	h.writeUser(rw, u, err)
+}
+
+func (h *HTTPHandler) ModifyUser(rw http.ResponseWriter, r *http.Request) {
+	userId := auth.UserId(r.Context())
+	if !validateUserId(userId) {
+		response := ErrorResponse{"Invalid or empty user id"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusUnauthorized)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if userId != mux.Vars(r)["userId"] {
+		response := ErrorResponse{"Forbidden access"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusForbidden)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	var patch user.Patch
+	_ = json.NewDecoder(r.Body).Decode(&patch)
+	if patch.Handle != nil {
+		handle := strings.ToLower(*patch.Handle)
+		patch.Handle = &handle
+		if !validateHandle(handle) {
+			response := ErrorResponse{"Invalid handle"}
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusBadRequest)
+			rawResponse, _ := json.Marshal(response)
+			_, _ = rw.Write(rawResponse)
+			return
+		}
+	}
+	u, err := h.storage.ModifyUser(r.Context(), userId, &patch)
+	if err == storage.ErrHandleTaken {
+		response := ErrorResponse{err.Error()}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusConflict)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	h.writeUser(rw, u, err)
+}
+
+func (h *HTTPHandler) writeUser(rw http.ResponseWriter, u *user.User, err error) {
+	if err == storage.ErrUserNotFound {
+		response := ErrorResponse{"User not found"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	if err != nil {
+		response := ErrorResponse{"Internal error"}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		rawResponse, _ := json.Marshal(response)
+		_, _ = rw.Write(rawResponse)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	ans, _ := json.Marshal(u)
+	_, _ = rw.Write(ans)
+}
+
+func validateHandle(handle string) bool {
+	r := regexp.MustCompile("^[a-z0-9_]{1,15}$")
+	return r.MatchString(handle)
+}
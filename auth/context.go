@@ -0,0 +1,16 @@
+package auth
+
+import "context"
+
+type contextKey struct{}
+
+// UserId returns the authenticated caller or an empty string for anonymous
+// requests.
+func UserId(ctx context.Context) string {
+	userId, _ := ctx.Value(contextKey{}).(string)
+	return userId
+}
+
+func WithUserId(ctx context.Context, userId string) context.Context {
+	return context.WithValue(ctx, contextKey{}, userId)
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt"
+	"time"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Tokens issues and verifies HS256 signed bearer tokens whose subject is the
+// user ID.
+type Tokens struct {
+	Secret []byte
+	TTL    time.Duration
+}
+
+func (t *Tokens) Issue(userId string) (string, error) {
+	now := time.Now()
+	claims := jwt.StandardClaims{
+		Subject:   userId,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(t.TTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(t.Secret)
+}
+
+func (t *Tokens) Parse(token string) (string, error) {
+	var claims jwt.StandardClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(tok *jwt.Token) (interface{}, error) {
+		if tok.Method != jwt.SigningMethodHS256 {
+			return nil, ErrInvalidToken
+		}
+		return t.Secret, nil
+	})
+	if err != nil || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
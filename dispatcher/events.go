@@ -0,0 +1,66 @@
+package dispatcher
+
+import "mini-twitter/domain/post"
+
+type Event interface {
+	Name() string
+}
+
+type PostCreated struct {
+	Post post.Post
+	Oid  string
+}
+
+type PostModified struct {
+	Post post.Post
+	Oid  string
+}
+
+type PostDeleted struct {
+	PostId   string
+	AuthorId string
+	Oid      string
+}
+
+type Subscribed struct {
+	Subscribee string
+	Subscriber string
+}
+
+type Unsubscribed struct {
+	Subscribee string
+	Subscriber string
+}
+
+// Notified asks the worker to create a notification of one of the types from
+// the notification package.
+type Notified struct {
+	UserId  string
+	Type    string
+	ActorId string
+	PostId  string
+}
+
+func (PostCreated) Name() string {
+	return "create"
+}
+
+func (PostModified) Name() string {
+	return "modify"
+}
+
+func (PostDeleted) Name() string {
+	return "delete"
+}
+
+func (Subscribed) Name() string {
+	return "subscribe"
+}
+
+func (Unsubscribed) Name() string {
+	return "unsubscribe"
+}
+
+func (Notified) Name() string {
+	return "notify"
+}
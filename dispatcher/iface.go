@@ -0,0 +1,12 @@
+package dispatcher
+
+import "context"
+
+// FeedDispatcher delivers feed events to whatever builds the feeds: the
+// machinery workers in production or an in-process pool in single-binary mode.
+type FeedDispatcher interface {
+	Dispatch(ctx context.Context, e Event) error
+}
+
+// Handler processes a single event on the consuming side.
+type Handler func(ctx context.Context, e Event) error
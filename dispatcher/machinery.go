@@ -0,0 +1,110 @@
+package dispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"mini-twitter/domain/post"
+)
+
+type MachineryDispatcher struct {
+	Server *machinery.Server
+}
+
+func (md *MachineryDispatcher) Dispatch(_ context.Context, e Event) error {
+	args, err := EventArgs(e)
+	if err != nil {
+		return err
+	}
+	rawArgs, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	policy := RetryPolicies[e.Name()]
+	signature := &tasks.Signature{
+		Name:         e.Name(),
+		Args:         make([]tasks.Arg, 0, len(args)),
+		RetryCount:   policy.Count,
+		RetryTimeout: policy.Timeout,
+		// Machinery calls error callbacks only after the last retry has
+		// failed and passes the error message as the first argument.
+		OnError: []*tasks.Signature{
+			{
+				Name: DeadLetterTask,
+				Args: []tasks.Arg{
+					{Type: "string", Value: e.Name()},
+					{Type: "string", Value: string(rawArgs)},
+				},
+			},
+		},
+	}
+	for _, arg := range args {
+		signature.Args = append(signature.Args, tasks.Arg{Type: "string", Value: arg})
+	}
+	_, err = md.Server.SendTask(signature)
+	return err
+}
+
+// EventArgs flattens an event into the positional string arguments expected by
+// the worker task registered under the event name.
+func EventArgs(e Event) ([]string, error) {
+	switch e := e.(type) {
+	case PostCreated:
+		return []string{e.Post.Id, e.Post.AuthorId, e.Post.Text, e.Post.CreatedAt, e.Post.LastModifiedAt, e.Oid}, nil
+	case PostModified:
+		return []string{e.Post.Id, e.Post.AuthorId, e.Post.Text, e.Post.CreatedAt, e.Post.LastModifiedAt, e.Oid}, nil
+	case PostDeleted:
+		return []string{e.PostId, e.AuthorId, e.Oid}, nil
+	case Subscribed:
+		return []string{e.Subscribee, e.Subscriber}, nil
+	case Unsubscribed:
+		return []string{e.Subscribee, e.Subscriber}, nil
+	case Notified:
+		return []string{e.UserId, e.Type, e.ActorId, e.PostId}, nil
+	}
+	return nil, fmt.Errorf("unknown event %q", e.Name())
+}
+
+// EventFromArgs is the inverse of EventArgs.
+func EventFromArgs(name string, args []string) (Event, error) {
+	argsCount := map[string]int{
+		PostCreated{}.Name():  6,
+		PostModified{}.Name(): 6,
+		PostDeleted{}.Name():  3,
+		Subscribed{}.Name():   2,
+		Unsubscribed{}.Name(): 2,
+		Notified{}.Name():     4,
+	}
+	count, ok := argsCount[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown event %q", name)
+	}
+	if len(args) != count {
+		return nil, fmt.Errorf("event %q expects %d args, got %d", name, count, len(args))
+	}
+	switch name {
+	case PostCreated{}.Name():
+		return PostCreated{Post: postFromArgs(args), Oid: args[5]}, nil
+	case PostModified{}.Name():
+		return PostModified{Post: postFromArgs(args), Oid: args[5]}, nil
+	case PostDeleted{}.Name():
+		return PostDeleted{PostId: args[0], AuthorId: args[1], Oid: args[2]}, nil
+	case Subscribed{}.Name():
+		return Subscribed{Subscribee: args[0], Subscriber: args[1]}, nil
+	case Notified{}.Name():
+		return Notified{UserId: args[0], Type: args[1], ActorId: args[2], PostId: args[3]}, nil
+	}
+	return Unsubscribed{Subscribee: args[0], Subscriber: args[1]}, nil
+}
+
+func postFromArgs(args []string) post.Post {
+	return post.Post{
+		Id:             args[0],
+		AuthorId:       args[1],
+		Text:           args[2],
+		CreatedAt:      args[3],
+		LastModifiedAt: args[4],
+	}
+}
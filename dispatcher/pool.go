@@ -0,0 +1,77 @@
+package dispatcher
+
+import (
+	"context"
+	"github.com/RichardKnop/machinery/v1/retry"
+	"log"
+	"sync"
+	"time"
+)
+
+// PoolDispatcher handles events inside the current process with a fixed number
+// of goroutines. Events are queued in a buffered channel, so Dispatch only
+// blocks when all workers are busy and the buffer is full. Failed events are
+// retried according to RetryPolicies and then passed to deadLetter.
+type PoolDispatcher struct {
+	events     chan Event
+	handler    Handler
+	deadLetter func(e Event, err error)
+	wg         sync.WaitGroup
+}
+
+func NewPoolDispatcher(workers int, handler Handler, deadLetter func(e Event, err error)) *PoolDispatcher {
+	pd := &PoolDispatcher{
+		events:     make(chan Event, 1024),
+		handler:    handler,
+		deadLetter: deadLetter,
+	}
+	pd.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pd.work()
+	}
+	return pd
+}
+
+func (pd *PoolDispatcher) Dispatch(ctx context.Context, e Event) error {
+	select {
+	case pd.events <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are handled.
+func (pd *PoolDispatcher) Close() {
+	close(pd.events)
+	pd.wg.Wait()
+}
+
+func (pd *PoolDispatcher) work() {
+	defer pd.wg.Done()
+	for e := range pd.events {
+		err := pd.handle(e)
+		if err == nil {
+			continue
+		}
+		log.Printf("failed to handle %s event: %v", e.Name(), err)
+		if pd.deadLetter != nil {
+			pd.deadLetter(e, err)
+		}
+	}
+}
+
+func (pd *PoolDispatcher) handle(e Event) error {
+	policy := RetryPolicies[e.Name()]
+	timeout := policy.Timeout
+	for attempt := 0; ; attempt++ {
+		// The request that produced the event may already be finished, so
+		// handlers get their own context.
+		err := pd.handler(context.Background(), e)
+		if err == nil || attempt >= policy.Count {
+			return err
+		}
+		timeout = retry.FibonacciNext(timeout)
+		time.Sleep(time.Duration(timeout) * time.Second)
+	}
+}
@@ -0,0 +1,106 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testEvent string
+
+func (e testEvent) Name() string {
+	return string(e)
+}
+
+func TestPoolDispatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		events  []testEvent
+		want    map[string]int
+	}{
+		{name: "no events", workers: 2, want: map[string]int{}},
+		{name: "one worker", workers: 1, events: []testEvent{"a", "b", "a"}, want: map[string]int{"a": 2, "b": 1}},
+		{name: "several workers", workers: 4, events: []testEvent{"a", "b", "c", "a", "b", "a"}, want: map[string]int{"a": 3, "b": 2, "c": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			handled := make(map[string]int)
+			handler := func(ctx context.Context, e Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				handled[e.Name()]++
+				return nil
+			}
+			pd := NewPoolDispatcher(tt.workers, handler, nil)
+			for _, e := range tt.events {
+				if err := pd.Dispatch(context.Background(), e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pd.Close()
+			if !reflect.DeepEqual(handled, tt.want) {
+				t.Errorf("handled = %v, want %v", handled, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolDispatcherRetries(t *testing.T) {
+	// Retries sleep for whole seconds, so the policies retry once at most.
+	RetryPolicies["retried"] = RetryPolicy{Count: 1, Timeout: 0}
+	defer delete(RetryPolicies, "retried")
+	errHandler := errors.New("handler failed")
+
+	tests := []struct {
+		name           string
+		event          testEvent
+		failures       int
+		wantCalls      int
+		wantDeadLetter bool
+	}{
+		{name: "success", event: "retried", failures: 0, wantCalls: 1},
+		{name: "success on retry", event: "retried", failures: 1, wantCalls: 2},
+		{name: "retries exhausted", event: "retried", failures: 2, wantCalls: 2, wantDeadLetter: true},
+		{name: "no retry policy", event: "unknown", failures: 1, wantCalls: 1, wantDeadLetter: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			calls := 0
+			var deadLetters []error
+			handler := func(ctx context.Context, e Event) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls++
+				if calls <= tt.failures {
+					return errHandler
+				}
+				return nil
+			}
+			deadLetter := func(e Event, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				deadLetters = append(deadLetters, err)
+			}
+			pd := NewPoolDispatcher(1, handler, deadLetter)
+			err := pd.Dispatch(context.Background(), tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pd.Close()
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantDeadLetter && (len(deadLetters) != 1 || deadLetters[0] != errHandler) {
+				t.Errorf("dead letters = %v, want [%v]", deadLetters, errHandler)
+			}
+			if !tt.wantDeadLetter && len(deadLetters) != 0 {
+				t.Errorf("dead letters = %v, want none", deadLetters)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package dispatcher
+
+// DeadLetterTask is the task that receives events whose retries are exhausted.
+const DeadLetterTask = "deadletter"
+
+type RetryPolicy struct {
+	Count int
+	// Timeout is the delay in seconds before the first retry, every next delay
+	// is the next Fibonacci number.
+	Timeout int
+}
+
+var RetryPolicies = map[string]RetryPolicy{
+	PostCreated{}.Name():  {Count: 5, Timeout: 1},
+	PostModified{}.Name(): {Count: 5, Timeout: 1},
+	PostDeleted{}.Name():  {Count: 5, Timeout: 1},
+	Subscribed{}.Name():   {Count: 3, Timeout: 2},
+	Unsubscribed{}.Name(): {Count: 3, Timeout: 2},
+	Notified{}.Name():     {Count: 3, Timeout: 2},
+}
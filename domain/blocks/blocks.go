@@ -0,0 +1,6 @@
+package blocks
+
+type Blocks struct {
+	UserId  string   `bson:"user"`
+	Blocked []string `bson:"blocked"`
+}
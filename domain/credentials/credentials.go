@@ -0,0 +1,6 @@
+package credentials
+
+type Credentials struct {
+	UserId       string `bson:"userId"`
+	PasswordHash []byte `bson:"passwordHash"`
+}
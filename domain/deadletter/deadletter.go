@@ -0,0 +1,11 @@
+package deadletter
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type DeadLetter struct {
+	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name     string             `json:"name" bson:"name"`
+	Args     []string           `json:"args" bson:"args"`
+	Error    string             `json:"error" bson:"error"`
+	FailedAt string             `json:"failedAt" bson:"failedAt"`
+}
@@ -6,21 +6,46 @@ import (
 )
 
 type Feed struct {
-	UserId         string             `bson:"userId"`
-	Id             string             `bson:"id"`
-	Text           string             `bson:"text"`
-	AuthorId       string             `bson:"authorId"`
-	CreatedAt      string             `bson:"createdAt"`
-	LastModifiedAt string             `bson:"lastModifiedAt"`
-	Oid            primitive.ObjectID `bson:"oid"`
+	UserId            string             `bson:"userId"`
+	Id                string             `bson:"id"`
+	Text              string             `bson:"text"`
+	AuthorId          string             `bson:"authorId"`
+	CreatedAt         string             `bson:"createdAt"`
+	LastModifiedAt    string             `bson:"lastModifiedAt"`
+	InReplyTo         string             `bson:"inReplyTo,omitempty"`
+	InReplyToAuthorId string             `bson:"inReplyToAuthorId,omitempty"`
+	ConversationId    string             `bson:"conversationId,omitempty"`
+	RepostOf          string             `bson:"repostOf,omitempty"`
+	QuoteOf           string             `bson:"quoteOf,omitempty"`
+	Original          *post.Post         `bson:"original,omitempty"`
+	Tags              []string           `bson:"tags,omitempty"`
+	Mentions          []post.Mention     `bson:"mentions,omitempty"`
+	Visibility        string             `bson:"visibility,omitempty"`
+	Oid               primitive.ObjectID `bson:"oid"`
 }
 
 func (f *Feed) ToPost() post.Post {
 	return post.Post{
-		Id:             f.Id,
-		Text:           f.Text,
-		AuthorId:       f.AuthorId,
-		CreatedAt:      f.CreatedAt,
-		LastModifiedAt: f.LastModifiedAt,
+		Id:                f.Id,
+		Text:              f.Text,
+		AuthorId:          f.AuthorId,
+		CreatedAt:         f.CreatedAt,
+		LastModifiedAt:    f.LastModifiedAt,
+		InReplyTo:         f.InReplyTo,
+		InReplyToAuthorId: f.InReplyToAuthorId,
+		ConversationId:    f.ConversationId,
+		RepostOf:          f.RepostOf,
+		QuoteOf:           f.QuoteOf,
+		Original:          f.Original,
+		Tags:              f.Tags,
+		Mentions:          f.Mentions,
+		Visibility:        f.Visibility,
 	}
 }
+
+// Entry is a post in the feed of a user together with its position in the
+// feed: the oid in MongoDB and the post id in memory.
+type Entry struct {
+	Oid  string
+	Post *post.Post
+}
@@ -0,0 +1,31 @@
+package filter
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Kind of a filter: a whole word or phrase, a hashtag or a regular expression.
+// All of them match regardless of case.
+const (
+	KindWord  = "word"
+	KindTag   = "tag"
+	KindRegex = "regex"
+)
+
+// Filter hides the posts matching Value from the feed of UserId until
+// ExpiresAt, or for good if it is nil. ExpiresAt is stored as a date, so that
+// MongoDB can compare it and drop the expired filters.
+type Filter struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId    string             `json:"-" bson:"userId"`
+	Kind      string             `json:"kind" bson:"kind"`
+	Value     string             `json:"value" bson:"value"`
+	ExpiresAt *time.Time         `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	CreatedAt string             `json:"createdAt" bson:"createdAt"`
+}
+
+// Active reports whether the filter has not expired at now.
+func (f *Filter) Active(now time.Time) bool {
+	return f.ExpiresAt == nil || f.ExpiresAt.After(now)
+}
@@ -0,0 +1,9 @@
+package followrequest
+
+// FollowRequest is a subscription to a protected account that waits for the
+// approval of its owner.
+type FollowRequest struct {
+	Subscribee string `bson:"subscribee"`
+	Subscriber string `bson:"subscriber"`
+	CreatedAt  string `bson:"createdAt"`
+}
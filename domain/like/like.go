@@ -0,0 +1,10 @@
+package like
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type Like struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserId    string             `bson:"userId"`
+	PostId    string             `bson:"postId"`
+	CreatedAt string             `bson:"createdAt"`
+}
@@ -0,0 +1,6 @@
+package mutes
+
+type Mutes struct {
+	UserId string   `bson:"user"`
+	Muted  []string `bson:"muted"`
+}
@@ -0,0 +1,23 @@
+package notification
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	TypeFollow        = "follow"
+	TypeFollowRequest = "follow_request"
+	TypeMention       = "mention"
+	TypeReply         = "reply"
+	TypeLike          = "like"
+)
+
+// Notification tells UserId that ActorId did something, optionally with the
+// post PostId.
+type Notification struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId    string             `json:"userId" bson:"userId"`
+	Type      string             `json:"type" bson:"type"`
+	ActorId   string             `json:"actorId" bson:"actorId"`
+	PostId    string             `json:"postId,omitempty" bson:"postId"`
+	Read      bool               `json:"read" bson:"read"`
+	CreatedAt string             `json:"createdAt" bson:"createdAt"`
+}
@@ -2,29 +2,126 @@ package post
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Visibility of a post. A post without one is public.
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityMentioned = "mentioned"
+)
+
+// Mention is a user mentioned in the text of a post. Start and End are the
+// offsets of the mention in characters, End is exclusive.
+type Mention struct {
+	UserId string `json:"userId" bson:"userId"`
+	Handle string `json:"handle" bson:"handle"`
+	Start  int    `json:"start" bson:"start"`
+	End    int    `json:"end" bson:"end"`
+}
+
 type Post struct {
-	Id             string `json:"id" bson:"id"`
-	Text           string `json:"text" bson:"text"`
-	AuthorId       string `json:"authorId" bson:"authorId"`
-	CreatedAt      string `json:"createdAt" bson:"createdAt"`
-	LastModifiedAt string `json:"lastModifiedAt" bson:"lastModifiedAt"`
+	Id                string    `json:"id" bson:"id"`
+	Text              string    `json:"text" bson:"text"`
+	AuthorId          string    `json:"authorId" bson:"authorId"`
+	CreatedAt         string    `json:"createdAt" bson:"createdAt"`
+	LastModifiedAt    string    `json:"lastModifiedAt" bson:"lastModifiedAt"`
+	InReplyTo         string    `json:"inReplyTo,omitempty" bson:"inReplyTo,omitempty"`
+	InReplyToAuthorId string    `json:"inReplyToAuthorId,omitempty" bson:"inReplyToAuthorId,omitempty"`
+	ConversationId    string    `json:"conversationId,omitempty" bson:"conversationId,omitempty"`
+	ReplyCount        int       `json:"replyCount" bson:"replyCount"`
+	RepostOf          string    `json:"repostOf,omitempty" bson:"repostOf,omitempty"`
+	QuoteOf           string    `json:"quoteOf,omitempty" bson:"quoteOf,omitempty"`
+	RepostCount       int       `json:"repostCount" bson:"repostCount"`
+	QuoteCount        int       `json:"quoteCount" bson:"quoteCount"`
+	LikeCount         int       `json:"likeCount" bson:"likeCount"`
+	Tags              []string  `json:"tags,omitempty" bson:"tags,omitempty"`
+	Mentions          []Mention `json:"mentions,omitempty" bson:"mentions,omitempty"`
+	Visibility        string    `json:"visibility,omitempty" bson:"visibility,omitempty"`
+	// Original is a copy of the reposted or quoted post. It is missing if
+	// that post was deleted.
+	Original *Post `json:"original,omitempty" bson:"original,omitempty"`
 }
 
 type PostWithOID struct {
-	ID             primitive.ObjectID `bson:"_id"`
-	Id             string             `bson:"id"`
-	Text           string             `bson:"text"`
-	AuthorId       string             `bson:"authorId"`
-	CreatedAt      string             `bson:"createdAt"`
-	LastModifiedAt string             `bson:"lastModifiedAt"`
+	ID                primitive.ObjectID `bson:"_id"`
+	Id                string             `bson:"id"`
+	Text              string             `bson:"text"`
+	AuthorId          string             `bson:"authorId"`
+	CreatedAt         string             `bson:"createdAt"`
+	LastModifiedAt    string             `bson:"lastModifiedAt"`
+	InReplyTo         string             `bson:"inReplyTo,omitempty"`
+	InReplyToAuthorId string             `bson:"inReplyToAuthorId,omitempty"`
+	ConversationId    string             `bson:"conversationId,omitempty"`
+	ReplyCount        int                `bson:"replyCount"`
+	RepostOf          string             `bson:"repostOf,omitempty"`
+	QuoteOf           string             `bson:"quoteOf,omitempty"`
+	RepostCount       int                `bson:"repostCount"`
+	QuoteCount        int                `bson:"quoteCount"`
+	LikeCount         int                `bson:"likeCount"`
+	Tags              []string           `bson:"tags,omitempty"`
+	Mentions          []Mention          `bson:"mentions,omitempty"`
+	Visibility        string             `bson:"visibility,omitempty"`
+	Original          *Post              `bson:"original,omitempty"`
+	// OnRead marks the posts that are read live instead of being fanned out.
+	OnRead bool `bson:"onRead,omitempty"`
 }
 
 func (pwo *PostWithOID) ToPost() Post {
 	return Post{
-		Id:             pwo.Id,
-		AuthorId:       pwo.AuthorId,
-		CreatedAt:      pwo.CreatedAt,
-		Text:           pwo.Text,
-		LastModifiedAt: pwo.LastModifiedAt,
+		Id:                pwo.Id,
+		AuthorId:          pwo.AuthorId,
+		CreatedAt:         pwo.CreatedAt,
+		Text:              pwo.Text,
+		LastModifiedAt:    pwo.LastModifiedAt,
+		InReplyTo:         pwo.InReplyTo,
+		InReplyToAuthorId: pwo.InReplyToAuthorId,
+		ConversationId:    pwo.ConversationId,
+		ReplyCount:        pwo.ReplyCount,
+		RepostOf:          pwo.RepostOf,
+		QuoteOf:           pwo.QuoteOf,
+		RepostCount:       pwo.RepostCount,
+		QuoteCount:        pwo.QuoteCount,
+		LikeCount:         pwo.LikeCount,
+		Tags:              pwo.Tags,
+		Mentions:          pwo.Mentions,
+		Visibility:        pwo.Visibility,
+		Original:          pwo.Original,
 	}
 }
+
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case "", VisibilityPublic, VisibilityFollowers, VisibilityMentioned:
+		return true
+	}
+	return false
+}
+
+// VisibleTo reports whether userId can see the post. follows tells whether the
+// user follows the author and protected whether the author's account is
+// protected, in which case only the followers see its posts.
+func (p *Post) VisibleTo(userId string, follows bool, protected bool) bool {
+	if userId == p.AuthorId {
+		return true
+	}
+	if protected && !follows {
+		return false
+	}
+	switch p.Visibility {
+	case VisibilityFollowers:
+		return follows
+	case VisibilityMentioned:
+		for _, mention := range p.Mentions {
+			if mention.UserId == userId {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Public reports whether everybody who can see the author's posts can see this
+// one, which is required to repost or quote it.
+func (p *Post) Public() bool {
+	return p.Visibility == "" || p.Visibility == VisibilityPublic
+}
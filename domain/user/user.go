@@ -0,0 +1,36 @@
+package user
+
+type User struct {
+	Id          string `json:"id" bson:"id"`
+	Handle      string `json:"handle" bson:"handle"`
+	DisplayName string `json:"displayName" bson:"displayName"`
+	Bio         string `json:"bio" bson:"bio"`
+	CreatedAt   string `json:"createdAt" bson:"createdAt"`
+	// Protected accounts approve their followers and hide their posts from
+	// everybody else.
+	Protected bool `json:"protected" bson:"protected"`
+}
+
+// Patch holds the fields of a partial profile update, nil fields are left
+// unchanged.
+type Patch struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"displayName"`
+	Bio         *string `json:"bio"`
+	Protected   *bool   `json:"protected"`
+}
+
+func (u *User) Apply(patch *Patch) {
+	if patch.Handle != nil {
+		u.Handle = *patch.Handle
+	}
+	if patch.DisplayName != nil {
+		u.DisplayName = *patch.DisplayName
+	}
+	if patch.Bio != nil {
+		u.Bio = *patch.Bio
+	}
+	if patch.Protected != nil {
+		u.Protected = *patch.Protected
+	}
+}
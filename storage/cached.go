@@ -3,7 +3,10 @@ package storage
 import (
 	_ "embed"
 	"encoding/json"
+	"mini-twitter/domain/feed"
+	"mini-twitter/domain/filter"
 	"mini-twitter/domain/post"
+	"mini-twitter/domain/user"
 	"strconv"
 	"time"
 )
@@ -18,14 +21,20 @@ type CachedStorage struct {
 	InternalStorage Storage
 }
 
-func (cs *CachedStorage) GetPostById(ctx context.Context, postId string) (*post.Post, error) {
+// GetPostById caches the posts regardless of the viewer and checks the
+// visibility of a cached post against the cached relation of the viewer.
+func (cs *CachedStorage) GetPostById(ctx context.Context, viewerId string, postId string) (*post.Post, error) {
 	var p *post.Post
+	var err error
 	p = cs.getByPIDKey(ctx, cs.postIdKey(postId))
 	if p != nil {
+		err = checkVisible(ctx, cs, viewerId, p)
+		if err != nil {
+			return nil, err
+		}
 		return p, nil
 	}
-	var err error
-	p, err = cs.InternalStorage.GetPostById(ctx, postId)
+	p, err = cs.InternalStorage.GetPostById(ctx, viewerId, postId)
 	if err != nil {
 		return nil, err
 	}
@@ -38,15 +47,28 @@ func (cs *CachedStorage) storeByPID(ctx context.Context, p *post.Post) {
 	_ = cs.Client.Set(ctx, cs.postIdKey(p.Id), string(res), time.Hour)
 }
 
-func (cs *CachedStorage) storeByUIDTokenSize(ctx context.Context, posts []*post.Post, newToken string, userId string, token string, size int) {
-	var res []byte
-	tmp := make(map[string]any)
-	if newToken != "" {
-		tmp["token"] = newToken
-	}
-	tmp["posts"] = posts
-	res, _ = json.Marshal(tmp)
-	cs.Client.Set(ctx, cs.uidTokenSizeKey(userId, token, size), string(res), time.Hour)
+func (cs *CachedStorage) storeByUIDTokenSize(ctx context.Context, page *Page, viewerId string, userId string, before string, since string, size int) {
+	cs.Client.Set(ctx, cs.uidTokenSizeKey(viewerId, userId, before, since, size), cs.marshalPage(page), time.Hour)
+}
+
+// storeFeedPage keeps all cached pages of a feed in a single hash, so that the
+// worker can invalidate a follower's feed with one DEL instead of a SCAN.
+func (cs *CachedStorage) storeFeedPage(ctx context.Context, page *Page, userId string, before string, since string, size int, ttl time.Duration) {
+	key := cs.feedKey(userId)
+	pipe := cs.Client.TxPipeline()
+	pipe.HSet(ctx, key, cs.tokenSizeField(before, since, size), cs.marshalPage(page))
+	pipe.Expire(ctx, key, ttl)
+	_, _ = pipe.Exec(ctx)
+}
+
+func (cs *CachedStorage) storeUsers(ctx context.Context, key string, users []string) {
+	res, _ := json.Marshal(users)
+	cs.Client.Set(ctx, key, string(res), time.Hour)
+}
+
+func (cs *CachedStorage) marshalPage(page *Page) string {
+	res, _ := json.Marshal(page)
+	return string(res)
 }
 
 func (cs *CachedStorage) getByPIDKey(ctx context.Context, key string) *post.Post {
@@ -60,27 +82,36 @@ func (cs *CachedStorage) getByPIDKey(ctx context.Context, key string) *post.Post
 	return nil
 }
 
-func (cs *CachedStorage) getByUIDTokenSizeKey(ctx context.Context, key string) ([]*post.Post, string, error) {
-	res := cs.Client.Get(ctx, key)
-	r, err := res.Result()
-	if err == nil {
-		var parsed map[string]any
-		_ = json.Unmarshal([]byte(r), &parsed)
-		var psts []post.Post
-		somebytes, _ := json.Marshal(parsed["posts"])
-		_ = json.Unmarshal(somebytes, &psts)
-		token, ok := parsed["token"].(string)
-		if !ok {
-			token = ""
-		}
-		var posts []*post.Post
-		for _, p := range psts {
-			tmpPost := p
-			posts = append(posts, &tmpPost)
-		}
-		return posts, token, nil
+func (cs *CachedStorage) getByUIDTokenSizeKey(ctx context.Context, key string) (*Page, error) {
+	r, err := cs.Client.Get(ctx, key).Result()
+	return cs.unmarshalPage(r, err)
+}
+
+func (cs *CachedStorage) getFeedPage(ctx context.Context, userId string, before string, since string, size int) (*Page, error) {
+	r, err := cs.Client.HGet(ctx, cs.feedKey(userId), cs.tokenSizeField(before, since, size)).Result()
+	return cs.unmarshalPage(r, err)
+}
+
+func (cs *CachedStorage) getUsers(ctx context.Context, key string) ([]string, error) {
+	r, err := cs.Client.Get(ctx, key).Result()
+	if err != nil {
+		return nil, ErrCacheMiss
+	}
+	users := make([]string, 0)
+	_ = json.Unmarshal([]byte(r), &users)
+	return users, nil
+}
+
+func (cs *CachedStorage) unmarshalPage(r string, err error) (*Page, error) {
+	if err != nil {
+		return nil, ErrCacheMiss
+	}
+	var page Page
+	err = json.Unmarshal([]byte(r), &page)
+	if err != nil || page.Posts == nil {
+		return nil, ErrCacheMiss
 	}
-	return nil, "", ErrCacheMiss
+	return &page, nil
 }
 
 func (cs *CachedStorage) findAndDeleteByPID(ctx context.Context, key string) {
@@ -91,34 +122,85 @@ func (cs *CachedStorage) findAndDeleteByUID(ctx context.Context, userId string)
 	cs.Client.Eval(ctx, scr, []string{"uts:" + userId + "*"})
 }
 
+// InvalidateFeed drops every cached page of the given users' feeds. The worker
+// calls it after it has changed the feed collection of these users.
+func (cs *CachedStorage) InvalidateFeed(ctx context.Context, userIds ...string) {
+	if len(userIds) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		keys = append(keys, cs.feedKey(userId))
+	}
+	cs.Client.Del(ctx, keys...)
+}
+
+// InvalidatePosts drops the cached copies of the given posts and the cached
+// pages of their authors' posts. The worker calls it after it has changed the
+// embedded copies of a reposted or quoted post.
+func (cs *CachedStorage) InvalidatePosts(ctx context.Context, posts ...*post.Post) {
+	for _, p := range posts {
+		cs.findAndDeleteByPID(ctx, cs.postIdKey(p.Id))
+		cs.findAndDeleteByUID(ctx, p.AuthorId)
+	}
+}
+
 func (cs *CachedStorage) postIdKey(postId string) string {
 	return "pid:" + postId
 }
 
-func (cs *CachedStorage) uidTokenSizeKey(userId string, token string, size int) string {
-	return "uts:" + userId + ":" + token + ":" + strconv.Itoa(size)
+// uidTokenSizeKey keys the pages of a user's posts by the viewer as well, since
+// the posts a viewer can see depend on whether the viewer follows the user.
+func (cs *CachedStorage) uidTokenSizeKey(viewerId string, userId string, before string, since string, size int) string {
+	return "uts:" + userId + ":" + viewerId + ":" + cs.tokenSizeField(before, since, size)
+}
+
+func (cs *CachedStorage) feedKey(userId string) string {
+	return "fd:" + userId
+}
+
+func (cs *CachedStorage) tokenSizeField(before string, since string, size int) string {
+	return before + ":" + since + ":" + strconv.Itoa(size)
+}
+
+func (cs *CachedStorage) userKey(userId string) string {
+	return "usr:" + userId
+}
+
+func (cs *CachedStorage) subscribersKey(userId string) string {
+	return "sbs:" + userId
 }
 
-func (cs *CachedStorage) AddPost(ctx context.Context, userId string, p *post.Post) {
-	cs.InternalStorage.AddPost(ctx, userId, p)
+func (cs *CachedStorage) subscriptionsKey(userId string) string {
+	return "sbn:" + userId
+}
+
+func (cs *CachedStorage) AddPost(ctx context.Context, userId string, p *post.Post) error {
+	err := cs.InternalStorage.AddPost(ctx, userId, p)
+	if err != nil {
+		return err
+	}
 	cs.findAndDeleteByUID(ctx, userId)
 	cs.storeByPID(ctx, p)
+	for _, relatedId := range []string{p.InReplyTo, p.QuoteOf} {
+		if relatedId != "" {
+			cs.findAndDeleteByPID(ctx, cs.postIdKey(relatedId))
+		}
+	}
+	return nil
 }
 
-func (cs *CachedStorage) GetPostsByUserId(ctx context.Context, userId string, token string, size int) ([]*post.Post, string, error) {
-	posts, newToken, err := cs.getByUIDTokenSizeKey(ctx, cs.uidTokenSizeKey(userId, token, size))
+func (cs *CachedStorage) GetPostsByUserId(ctx context.Context, viewerId string, userId string, before string, since string, size int) (*Page, error) {
+	page, err := cs.getByUIDTokenSizeKey(ctx, cs.uidTokenSizeKey(viewerId, userId, before, since, size))
 	if err == nil {
-		return posts, newToken, nil
+		return page, nil
 	}
-	posts, newToken, err = cs.InternalStorage.GetPostsByUserId(ctx, userId, token, size)
+	page, err = cs.InternalStorage.GetPostsByUserId(ctx, viewerId, userId, before, since, size)
 	if err != nil {
-		return posts, newToken, err
-	}
-	if size == DEFAULT {
-		size = 10
+		return page, err
 	}
-	cs.storeByUIDTokenSize(ctx, posts, newToken, userId, token, size)
-	return posts, newToken, err
+	cs.storeByUIDTokenSize(ctx, page, viewerId, userId, before, since, size)
+	return page, err
 }
 
 func (cs *CachedStorage) ModifyPost(ctx context.Context, userId string, postId string, newPost *post.Post) (*post.Post, error) {
@@ -130,3 +212,312 @@ func (cs *CachedStorage) ModifyPost(ctx context.Context, userId string, postId s
 	cs.findAndDeleteByUID(ctx, userId)
 	return p, nil
 }
+
+func (cs *CachedStorage) DeletePost(ctx context.Context, userId string, postId string) error {
+	p, _ := cs.InternalStorage.GetPostById(ctx, userId, postId)
+	err := cs.InternalStorage.DeletePost(ctx, userId, postId)
+	if err != nil {
+		return err
+	}
+	cs.findAndDeleteByPID(ctx, cs.postIdKey(postId))
+	cs.findAndDeleteByUID(ctx, userId)
+	if p != nil {
+		for _, relatedId := range []string{p.InReplyTo, p.RepostOf, p.QuoteOf} {
+			if relatedId != "" {
+				cs.findAndDeleteByPID(ctx, cs.postIdKey(relatedId))
+			}
+		}
+	}
+	return nil
+}
+
+func (cs *CachedStorage) Repost(ctx context.Context, userId string, postId string) (*post.Post, error) {
+	p, err := cs.InternalStorage.Repost(ctx, userId, postId)
+	if err != nil {
+		return nil, err
+	}
+	cs.findAndDeleteByUID(ctx, userId)
+	cs.storeByPID(ctx, p)
+	cs.findAndDeleteByPID(ctx, cs.postIdKey(p.RepostOf))
+	return p, nil
+}
+
+func (cs *CachedStorage) Like(ctx context.Context, userId string, postId string) error {
+	err := cs.InternalStorage.Like(ctx, userId, postId)
+	if err != nil {
+		return err
+	}
+	cs.dropLikedPost(ctx, postId)
+	return nil
+}
+
+func (cs *CachedStorage) Unlike(ctx context.Context, userId string, postId string) error {
+	err := cs.InternalStorage.Unlike(ctx, userId, postId)
+	if err != nil {
+		return err
+	}
+	cs.dropLikedPost(ctx, postId)
+	return nil
+}
+
+// dropLikedPost drops the cached post whose like count has changed. If the
+// cached post is a repost, the like went to the reposted post.
+func (cs *CachedStorage) dropLikedPost(ctx context.Context, postId string) {
+	key := cs.postIdKey(postId)
+	if p := cs.getByPIDKey(ctx, key); p != nil && p.RepostOf != "" {
+		cs.findAndDeleteByPID(ctx, cs.postIdKey(p.RepostOf))
+	}
+	cs.findAndDeleteByPID(ctx, key)
+}
+
+func (cs *CachedStorage) GetLikes(ctx context.Context, postId string, token string, size int) ([]string, string, error) {
+	return cs.InternalStorage.GetLikes(ctx, postId, token, size)
+}
+
+func (cs *CachedStorage) GetLikedPosts(ctx context.Context, viewerId string, userId string, token string, size int) ([]*post.Post, string, error) {
+	return cs.InternalStorage.GetLikedPosts(ctx, viewerId, userId, token, size)
+}
+
+func (cs *CachedStorage) GetPostsByTag(ctx context.Context, viewerId string, tag string, token string, size int) ([]*post.Post, string, error) {
+	return cs.InternalStorage.GetPostsByTag(ctx, viewerId, tag, token, size)
+}
+
+func (cs *CachedStorage) GetReplies(ctx context.Context, viewerId string, postId string, token string, size int) ([]*post.Post, string, error) {
+	return cs.InternalStorage.GetReplies(ctx, viewerId, postId, token, size)
+}
+
+func (cs *CachedStorage) GetThread(ctx context.Context, viewerId string, postId string) ([]*post.Post, error) {
+	return cs.InternalStorage.GetThread(ctx, viewerId, postId)
+}
+
+func (cs *CachedStorage) GetConversationMembers(ctx context.Context, viewerId string, conversationId string) ([]string, error) {
+	return cs.InternalStorage.GetConversationMembers(ctx, viewerId, conversationId)
+}
+
+func (cs *CachedStorage) Subscribe(ctx context.Context, subscribee string, subscriber string) error {
+	err := cs.InternalStorage.Subscribe(ctx, subscribee, subscriber)
+	if err != nil {
+		return err
+	}
+	cs.dropSubscription(ctx, subscribee, subscriber)
+	return nil
+}
+
+func (cs *CachedStorage) Unsubscribe(ctx context.Context, subscribee string, subscriber string) error {
+	err := cs.InternalStorage.Unsubscribe(ctx, subscribee, subscriber)
+	if err != nil {
+		return err
+	}
+	cs.dropSubscription(ctx, subscribee, subscriber)
+	return nil
+}
+
+// dropSubscription drops the cached lists of a subscription and the pages of
+// the subscribee's posts cached for the subscriber.
+func (cs *CachedStorage) dropSubscription(ctx context.Context, subscribee string, subscriber string) {
+	cs.findAndDeleteByPID(ctx, cs.subscribersKey(subscribee))
+	cs.findAndDeleteByPID(ctx, cs.subscriptionsKey(subscriber))
+	cs.Client.Eval(ctx, scr, []string{"uts:" + subscribee + ":" + subscriber + ":*"})
+}
+
+func (cs *CachedStorage) GetFollowRequests(ctx context.Context, userId string) ([]string, error) {
+	return cs.InternalStorage.GetFollowRequests(ctx, userId)
+}
+
+func (cs *CachedStorage) ApproveFollowRequest(ctx context.Context, userId string, subscriber string) error {
+	err := cs.InternalStorage.ApproveFollowRequest(ctx, userId, subscriber)
+	if err != nil {
+		return err
+	}
+	cs.dropSubscription(ctx, userId, subscriber)
+	return nil
+}
+
+func (cs *CachedStorage) RejectFollowRequest(ctx context.Context, userId string, subscriber string) error {
+	return cs.InternalStorage.RejectFollowRequest(ctx, userId, subscriber)
+}
+
+func (cs *CachedStorage) Block(ctx context.Context, userId string, blockedId string) error {
+	err := cs.InternalStorage.Block(ctx, userId, blockedId)
+	if err != nil {
+		return err
+	}
+	cs.dropSubscription(ctx, userId, blockedId)
+	cs.dropSubscription(ctx, blockedId, userId)
+	cs.InvalidateFeed(ctx, userId, blockedId)
+	return nil
+}
+
+func (cs *CachedStorage) Unblock(ctx context.Context, userId string, blockedId string) error {
+	return cs.InternalStorage.Unblock(ctx, userId, blockedId)
+}
+
+func (cs *CachedStorage) GetBlocked(ctx context.Context, userId string) ([]string, error) {
+	return cs.InternalStorage.GetBlocked(ctx, userId)
+}
+
+func (cs *CachedStorage) GetBlockedWith(ctx context.Context, userId string) ([]string, error) {
+	return cs.InternalStorage.GetBlockedWith(ctx, userId)
+}
+
+func (cs *CachedStorage) Mute(ctx context.Context, userId string, mutedId string) error {
+	err := cs.InternalStorage.Mute(ctx, userId, mutedId)
+	if err != nil {
+		return err
+	}
+	cs.InvalidateFeed(ctx, userId)
+	return nil
+}
+
+func (cs *CachedStorage) Unmute(ctx context.Context, userId string, mutedId string) error {
+	err := cs.InternalStorage.Unmute(ctx, userId, mutedId)
+	if err != nil {
+		return err
+	}
+	cs.InvalidateFeed(ctx, userId)
+	return nil
+}
+
+func (cs *CachedStorage) GetMuted(ctx context.Context, userId string) ([]string, error) {
+	return cs.InternalStorage.GetMuted(ctx, userId)
+}
+
+func (cs *CachedStorage) GetSubscribers(ctx context.Context, userId string) ([]string, error) {
+	users, err := cs.getUsers(ctx, cs.subscribersKey(userId))
+	if err == nil {
+		return users, nil
+	}
+	users, err = cs.InternalStorage.GetSubscribers(ctx, userId)
+	if err != nil {
+		return users, err
+	}
+	cs.storeUsers(ctx, cs.subscribersKey(userId), users)
+	return users, nil
+}
+
+func (cs *CachedStorage) GetSubscriptions(ctx context.Context, userId string) ([]string, error) {
+	users, err := cs.getUsers(ctx, cs.subscriptionsKey(userId))
+	if err == nil {
+		return users, nil
+	}
+	users, err = cs.InternalStorage.GetSubscriptions(ctx, userId)
+	if err != nil {
+		return users, err
+	}
+	cs.storeUsers(ctx, cs.subscriptionsKey(userId), users)
+	return users, nil
+}
+
+func (cs *CachedStorage) GetFeed(ctx context.Context, userId string, before string, since string, size int) (*Page, error) {
+	page, err := cs.getFeedPage(ctx, userId, before, since, size)
+	if err == nil {
+		return page, nil
+	}
+	page, err = cs.InternalStorage.GetFeed(ctx, userId, before, since, size)
+	if err != nil {
+		return page, err
+	}
+	ttl, err := cs.feedTTL(ctx, userId)
+	if err == nil && ttl > 0 {
+		cs.storeFeedPage(ctx, page, userId, before, since, size, ttl)
+	}
+	return page, nil
+}
+
+// liveFeedTTL bounds how long a cached feed page may miss the changes of posts
+// that are read live. The worker does not invalidate the feeds of all followers
+// of their authors.
+const liveFeedTTL = time.Minute
+
+// feedTTL keeps the pages of a feed cached until the first of its filters
+// expires, since the posts it hides must show up again then.
+func (cs *CachedStorage) feedTTL(ctx context.Context, userId string) (time.Duration, error) {
+	filters, err := cs.InternalStorage.GetFilters(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	ttl := liveFeedTTL
+	for _, f := range filters {
+		if f.ExpiresAt == nil {
+			continue
+		}
+		if left := time.Until(*f.ExpiresAt); left < ttl {
+			ttl = left
+		}
+	}
+	return ttl, nil
+}
+
+func (cs *CachedStorage) AddFilter(ctx context.Context, userId string, f *filter.Filter) error {
+	err := cs.InternalStorage.AddFilter(ctx, userId, f)
+	if err != nil {
+		return err
+	}
+	cs.InvalidateFeed(ctx, userId)
+	return nil
+}
+
+func (cs *CachedStorage) GetFilters(ctx context.Context, userId string) ([]*filter.Filter, error) {
+	return cs.InternalStorage.GetFilters(ctx, userId)
+}
+
+func (cs *CachedStorage) DeleteFilter(ctx context.Context, userId string, filterId string) error {
+	err := cs.InternalStorage.DeleteFilter(ctx, userId, filterId)
+	if err != nil {
+		return err
+	}
+	cs.InvalidateFeed(ctx, userId)
+	return nil
+}
+
+func (cs *CachedStorage) GetFeedSince(ctx context.Context, userId string, oid string, size int) ([]feed.Entry, error) {
+	return cs.InternalStorage.GetFeedSince(ctx, userId, oid, size)
+}
+
+func (cs *CachedStorage) AddUser(ctx context.Context, u *user.User) error {
+	return cs.InternalStorage.AddUser(ctx, u)
+}
+
+func (cs *CachedStorage) GetUserById(ctx context.Context, userId string) (*user.User, error) {
+	r, err := cs.Client.Get(ctx, cs.userKey(userId)).Result()
+	if err == nil {
+		var u user.User
+		_ = json.Unmarshal([]byte(r), &u)
+		return &u, nil
+	}
+	u, err := cs.InternalStorage.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	res, _ := json.Marshal(u)
+	cs.Client.Set(ctx, cs.userKey(userId), string(res), time.Hour)
+	return u, nil
+}
+
+func (cs *CachedStorage) GetUserByHandle(ctx context.Context, handle string) (*user.User, error) {
+	return cs.InternalStorage.GetUserByHandle(ctx, handle)
+}
+
+func (cs *CachedStorage) ModifyUser(ctx context.Context, userId string, patch *user.Patch) (*user.User, error) {
+	// Opening the account approves the pending follow requests.
+	var requests []string
+	if patch.Protected != nil && !*patch.Protected {
+		var err error
+		requests, err = cs.InternalStorage.GetFollowRequests(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	u, err := cs.InternalStorage.ModifyUser(ctx, userId, patch)
+	if err != nil {
+		return nil, err
+	}
+	cs.findAndDeleteByPID(ctx, cs.userKey(userId))
+	if patch.Protected != nil {
+		cs.findAndDeleteByUID(ctx, userId)
+	}
+	for _, subscriber := range requests {
+		cs.dropSubscription(ctx, userId, subscriber)
+	}
+	return u, nil
+}
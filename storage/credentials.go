@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"mini-twitter/domain/credentials"
+	"sync"
+)
+
+type CredentialStorage interface {
+	AddCredentials(ctx context.Context, c *credentials.Credentials) error
+	GetCredentials(ctx context.Context, userId string) (*credentials.Credentials, error)
+}
+
+type MongoCredentialStorage struct {
+	Credentials *mongo.Collection
+}
+
+func (m *MongoCredentialStorage) AddCredentials(ctx context.Context, c *credentials.Credentials) error {
+	_, err := m.Credentials.InsertOne(ctx, c)
+	return err
+}
+
+func (m *MongoCredentialStorage) GetCredentials(ctx context.Context, userId string) (*credentials.Credentials, error) {
+	var c credentials.Credentials
+	err := m.Credentials.FindOne(ctx, bson.M{"userId": userId}).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+type InMemoryCredentialStorage struct {
+	mu          sync.RWMutex
+	Credentials map[string]credentials.Credentials
+}
+
+func NewInMemoryCredentialStorage() *InMemoryCredentialStorage {
+	return &InMemoryCredentialStorage{Credentials: make(map[string]credentials.Credentials)}
+}
+
+func (im *InMemoryCredentialStorage) AddCredentials(_ context.Context, c *credentials.Credentials) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.Credentials[c.UserId] = *c
+	return nil
+}
+
+func (im *InMemoryCredentialStorage) GetCredentials(_ context.Context, userId string) (*credentials.Credentials, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	c, ok := im.Credentials[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &c, nil
+}
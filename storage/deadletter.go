@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mini-twitter/domain/deadletter"
+	"mini-twitter/utils"
+)
+
+type DeadLetterStorage interface {
+	AddDeadLetter(ctx context.Context, d *deadletter.DeadLetter) error
+	GetDeadLetterById(ctx context.Context, id string) (*deadletter.DeadLetter, error)
+	GetDeadLetters(ctx context.Context, token string, size int) ([]*deadletter.DeadLetter, string, error)
+	RemoveDeadLetter(ctx context.Context, id string) error
+}
+
+type MongoDeadLetterStorage struct {
+	DeadLetters *mongo.Collection
+	Pages       *PageTokens
+}
+
+func (m *MongoDeadLetterStorage) AddDeadLetter(ctx context.Context, d *deadletter.DeadLetter) error {
+	d.FailedAt = utils.GetCurrentTimestamp()
+	insertRes, err := m.DeadLetters.InsertOne(ctx, d)
+	if err != nil {
+		return err
+	}
+	d.ID = insertRes.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (m *MongoDeadLetterStorage) GetDeadLetterById(ctx context.Context, id string) (*deadletter.DeadLetter, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	var d deadletter.DeadLetter
+	err = m.DeadLetters.FindOne(ctx, bson.M{"_id": oid}).Decode(&d)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (m *MongoDeadLetterStorage) GetDeadLetters(ctx context.Context, token string, size int) ([]*deadletter.DeadLetter, string, error) {
+	arr := make([]*deadletter.DeadLetter, 0)
+	var filter = bson.M{}
+	oid, size, err := m.Pages.DecodeOid(deadLettersOwner, token, size)
+	if err != nil {
+		return arr, "", err
+	}
+	if !oid.IsZero() {
+		filter = bson.M{"_id": bson.M{"$lt": oid}}
+	}
+	if size == DEFAULT {
+		size = 10
+	}
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", -1}})
+	opt.SetLimit(int64(size) + 1)
+	cur, err := m.DeadLetters.Find(ctx, filter, opt)
+	if err != nil {
+		return arr, "", err
+	}
+	err = cur.All(ctx, &arr)
+	if err != nil {
+		return arr, "", err
+	}
+	retToken := ""
+	if len(arr) > size {
+		arr = arr[:size]
+		retToken = m.Pages.Encode(deadLettersOwner, arr[size-1].ID.Hex(), size)
+	}
+	return arr, retToken, nil
+}
+
+func (m *MongoDeadLetterStorage) RemoveDeadLetter(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrDeadLetterNotFound
+	}
+	_, err = m.DeadLetters.DeleteOne(ctx, bson.M{"_id": oid})
+	return err
+}
@@ -7,3 +7,16 @@ var ErrParseToken = errors.New("error parse token")
 var ErrForbiddenAccess = errors.New("forbidden access")
 var ErrCacheMiss = errors.New("cache miss")
 var ErrInvalidSubscribe = errors.New("cannot subscribe on this user")
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+var ErrUserNotFound = errors.New("user not found")
+var ErrUserExists = errors.New("user already exists")
+var ErrHandleTaken = errors.New("handle is already taken")
+var ErrParentNotFound = errors.New("replied post not found")
+var ErrNotificationNotFound = errors.New("notification not found")
+var ErrAlreadyReposted = errors.New("post is already reposted")
+var ErrFollowRequested = errors.New("follow request is pending approval")
+var ErrFollowRequestNotFound = errors.New("follow request not found")
+var ErrBlocked = errors.New("user is blocked")
+var ErrInvalidBlock = errors.New("cannot block or mute this user")
+var ErrTooManyFilters = errors.New("too many filters")
+var ErrFilterNotFound = errors.New("filter not found")
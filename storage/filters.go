@@ -0,0 +1,4 @@
+package storage
+
+// MaxFilters bounds the number of active feed filters of a user.
+const MaxFilters = 100
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"mini-twitter/domain/post"
+	"mini-twitter/domain/user"
+	"testing"
+)
+
+const (
+	alice = "aaaaaaaaaaaaaaaaaaaaaaaa"
+	bob   = "bbbbbbbbbbbbbbbbbbbbbbbb"
+	carol = "cccccccccccccccccccccccc"
+)
+
+func newTestStorage(t *testing.T) *InMemoryStorage {
+	t.Helper()
+	pages := &PageTokens{Secret: []byte("secret")}
+	im := NewInMemoryStorage(pages)
+	im.Notifications = NewInMemoryNotificationStorage(pages)
+	for id, handle := range map[string]string{alice: "alice", bob: "bob", carol: "carol"} {
+		err := im.AddUser(context.Background(), &user.User{Id: id, Handle: handle})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return im
+}
+
+func addTestPost(t *testing.T, im *InMemoryStorage, userId string, p *post.Post) string {
+	t.Helper()
+	err := im.AddPost(context.Background(), userId, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Id
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,16 +2,78 @@ package storage
 
 import (
 	"context"
+	"mini-twitter/domain/feed"
+	"mini-twitter/domain/filter"
 	"mini-twitter/domain/post"
+	"mini-twitter/domain/user"
 )
 
 type Storage interface {
-	GetPostById(ctx context.Context, postId string) (*post.Post, error)
-	AddPost(ctx context.Context, userId string, p *post.Post)
-	GetPostsByUserId(ctx context.Context, userId string, token string, size int) ([]*post.Post, string, error)
+	// GetPostById returns ErrPostNotFound for the posts viewerId cannot see.
+	GetPostById(ctx context.Context, viewerId string, postId string) (*post.Post, error)
+	AddPost(ctx context.Context, userId string, p *post.Post) error
+	// GetPostsByUserId returns the page of the user's posts that viewerId can
+	// see, older than before or newer than since. It returns ErrForbiddenAccess
+	// if the account is protected and viewerId does not follow it, and
+	// ErrBlocked if the user blocked viewerId.
+	GetPostsByUserId(ctx context.Context, viewerId string, userId string, before string, since string, size int) (*Page, error)
 	ModifyPost(ctx context.Context, userId string, postId string, newPost *post.Post) (*post.Post, error)
+	DeletePost(ctx context.Context, userId string, postId string) error
+	Repost(ctx context.Context, userId string, postId string) (*post.Post, error)
+	// GetPostsByTag and GetReplies leave out the posts viewerId cannot see.
+	GetPostsByTag(ctx context.Context, viewerId string, tag string, token string, size int) ([]*post.Post, string, error)
+	GetReplies(ctx context.Context, viewerId string, postId string, token string, size int) ([]*post.Post, string, error)
+	// GetThread returns the ancestors of the post that viewerId can see, up to
+	// the first one hidden from the viewer, root first.
+	GetThread(ctx context.Context, viewerId string, postId string) ([]*post.Post, error)
+	// GetConversationMembers returns the authors of the posts of a conversation.
+	// It returns ErrPostNotFound if viewerId cannot see the root post.
+	GetConversationMembers(ctx context.Context, viewerId string, conversationId string) ([]string, error)
+	Like(ctx context.Context, userId string, postId string) error
+	Unlike(ctx context.Context, userId string, postId string) error
+	GetLikes(ctx context.Context, postId string, token string, size int) ([]string, string, error)
+	// GetLikedPosts leaves out the posts viewerId cannot see, so a page may be
+	// shorter than size. It returns ErrForbiddenAccess and ErrBlocked like
+	// GetPostsByUserId.
+	GetLikedPosts(ctx context.Context, viewerId string, userId string, token string, size int) ([]*post.Post, string, error)
+	// Subscribe creates a follow request and returns ErrFollowRequested if the
+	// account of subscribee is protected. It returns ErrBlocked if either user
+	// blocked the other.
 	Subscribe(ctx context.Context, subscribee string, subscriber string) error
+	Unsubscribe(ctx context.Context, subscribee string, subscriber string) error
 	GetSubscribers(ctx context.Context, userId string) ([]string, error)
 	GetSubscriptions(ctx context.Context, userId string) ([]string, error)
-	GetFeed(ctx context.Context, userId string, token string, size int) ([]*post.Post, string, error)
+	// GetFollowRequests returns the users waiting for the approval of userId,
+	// oldest request first.
+	GetFollowRequests(ctx context.Context, userId string) ([]string, error)
+	ApproveFollowRequest(ctx context.Context, userId string, subscriber string) error
+	RejectFollowRequest(ctx context.Context, userId string, subscriber string) error
+	// Block removes the subscriptions between the users in both directions and
+	// hides the posts of userId from blockedId.
+	Block(ctx context.Context, userId string, blockedId string) error
+	Unblock(ctx context.Context, userId string, blockedId string) error
+	GetBlocked(ctx context.Context, userId string) ([]string, error)
+	// GetBlockedWith returns the users whom userId blocked or who blocked
+	// userId.
+	GetBlockedWith(ctx context.Context, userId string) ([]string, error)
+	// Mute hides the posts of mutedId from the feed of userId.
+	Mute(ctx context.Context, userId string, mutedId string) error
+	Unmute(ctx context.Context, userId string, mutedId string) error
+	GetMuted(ctx context.Context, userId string) ([]string, error)
+	// AddFilter stores a feed filter of userId. It returns ErrTooManyFilters
+	// if the user already has MaxFilters active filters.
+	AddFilter(ctx context.Context, userId string, f *filter.Filter) error
+	// GetFilters returns the filters of userId that have not expired.
+	GetFilters(ctx context.Context, userId string) ([]*filter.Filter, error)
+	DeleteFilter(ctx context.Context, userId string, filterId string) error
+	// GetFeed leaves out the posts hidden by the filters of the user, and still
+	// returns full pages unless it has read maxFilteredReads pages of the feed.
+	GetFeed(ctx context.Context, userId string, before string, since string, size int) (*Page, error)
+	// GetFeedSince returns up to size entries of the feed that are newer than
+	// the given position, oldest first.
+	GetFeedSince(ctx context.Context, userId string, oid string, size int) ([]feed.Entry, error)
+	AddUser(ctx context.Context, u *user.User) error
+	GetUserById(ctx context.Context, userId string) (*user.User, error)
+	GetUserByHandle(ctx context.Context, handle string) (*user.User, error)
+	ModifyUser(ctx context.Context, userId string, patch *user.Patch) (*user.User, error)
 }
@@ -3,40 +3,165 @@ package storage
 import (
 	"container/list"
 	"context"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mini-twitter/domain/feed"
+	"mini-twitter/domain/filter"
+	"mini-twitter/domain/notification"
 	"mini-twitter/domain/post"
+	"mini-twitter/domain/user"
+	"mini-twitter/stream"
 	"mini-twitter/utils"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 )
 
 const DEFAULT = -1
 
+// MaxThreadDepth bounds the number of ancestors returned by GetThread.
+const MaxThreadDepth = 100
+
 type InMemoryStorage struct {
 	mu               sync.RWMutex
 	Posts            *list.List
 	PostIdToPost     map[string]*list.Element
 	UserIdToPostsIds map[string][]string
 	PostIdToIdx      map[string]int
+	PostIdToSeq      map[string]int
+	Subscribers      map[string][]string
+	Subscriptions    map[string][]string
+	UserIdToFeed     map[string][]string
+	PostIdToReplies  map[string][]string
+	PostIdToReposts  map[string][]string
+	PostIdToLikes    map[string][]string
+	UserIdToLikes    map[string][]string
+	TagToPostsIds    map[string][]string
+	Users            map[string]*user.User
+	HandleToUserId   map[string]string
+	FollowRequests   map[string][]string
+	Blocked          map[string][]string
+	Muted            map[string][]string
+	Filters          map[string][]*filter.Filter
+	// Notifications receives the notifications that the worker would create
+	// in the other modes.
+	Notifications NotificationStorage
+	// Broker receives the feed updates that the worker would publish in the
+	// other modes.
+	Broker stream.Broker
+	Pages  *PageTokens
+	seq    int
+}
+
+func NewInMemoryStorage(pages *PageTokens) *InMemoryStorage {
+	return &InMemoryStorage{
+		Pages:            pages,
+		Posts:            list.New(),
+		PostIdToPost:     make(map[string]*list.Element),
+		UserIdToPostsIds: make(map[string][]string),
+		PostIdToIdx:      make(map[string]int),
+		PostIdToSeq:      make(map[string]int),
+		Subscribers:      make(map[string][]string),
+		Subscriptions:    make(map[string][]string),
+		UserIdToFeed:     make(map[string][]string),
+		PostIdToReplies:  make(map[string][]string),
+		PostIdToReposts:  make(map[string][]string),
+		PostIdToLikes:    make(map[string][]string),
+		UserIdToLikes:    make(map[string][]string),
+		TagToPostsIds:    make(map[string][]string),
+		Users:            make(map[string]*user.User),
+		HandleToUserId:   make(map[string]string),
+		FollowRequests:   make(map[string][]string),
+		Blocked:          make(map[string][]string),
+		Muted:            make(map[string][]string),
+		Filters:          make(map[string][]*filter.Filter),
+	}
 }
 
-func (im *InMemoryStorage) GetPostById(_ context.Context, postId string) (*post.Post, error) {
+func (im *InMemoryStorage) GetPostById(_ context.Context, viewerId string, postId string) (*post.Post, error) {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 	elem, ok := im.PostIdToPost[postId]
-	if !ok {
+	if !ok || !im.visibleTo(elem.Value.(*post.Post), viewerId) {
 		return nil, ErrPostNotFound
 	}
-	p := elem.Value.(*post.Post)
+	return im.copyPost(elem.Value.(*post.Post)), nil
+}
+
+func (im *InMemoryStorage) AddPost(_ context.Context, userId string, p *post.Post) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.Users[userId]; !ok {
+		return ErrUserNotFound
+	}
+	p.RepostOf = ""
+	if p.QuoteOf != "" {
+		original := im.original(p.QuoteOf)
+		if original == nil {
+			return ErrPostNotFound
+		}
+		err := im.shareable(original, userId)
+		if err != nil {
+			return err
+		}
+		p.QuoteOf = original.Id
+	}
+	return im.addPost(userId, p)
+}
+
+func (im *InMemoryStorage) Repost(_ context.Context, userId string, postId string) (*post.Post, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.Users[userId]; !ok {
+		return nil, ErrUserNotFound
+	}
+	original := im.original(postId)
+	if original == nil {
+		return nil, ErrPostNotFound
+	}
+	err := im.shareable(original, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, repostId := range im.PostIdToReposts[original.Id] {
+		if im.PostIdToPost[repostId].Value.(*post.Post).AuthorId == userId {
+			return nil, ErrAlreadyReposted
+		}
+	}
+	p := &post.Post{RepostOf: original.Id}
+	err = im.addPost(userId, p)
+	if err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
-func (im *InMemoryStorage) AddPost(_ context.Context, userId string, p *post.Post) {
+func (im *InMemoryStorage) addPost(userId string, p *post.Post) error {
 	p.CreatedAt = utils.GetCurrentTimestamp()
 	p.LastModifiedAt = utils.GetCurrentTimestamp()
 	p.AuthorId = userId
-	im.mu.Lock()
-	defer im.mu.Unlock()
+	p.InReplyToAuthorId = ""
+	p.ConversationId = ""
+	p.ReplyCount = 0
+	p.RepostCount = 0
+	p.QuoteCount = 0
+	p.LikeCount = 0
+	p.Original = nil
+	p.Tags = utils.ExtractTags(p.Text)
+	p.Mentions = im.resolveMentions(p.Text)
+	if p.Visibility == "" {
+		p.Visibility = post.VisibilityPublic
+	}
+	var parent *post.Post
+	if p.InReplyTo != "" {
+		elem, ok := im.PostIdToPost[p.InReplyTo]
+		if !ok || !im.visibleTo(elem.Value.(*post.Post), userId) {
+			return ErrParentNotFound
+		}
+		parent = elem.Value.(*post.Post)
+		p.InReplyToAuthorId = parent.AuthorId
+		p.ConversationId = parent.ConversationId
+	}
 	for true {
 		p.Id = utils.GeneratePostId()
 		_, ok := im.PostIdToPost[p.Id]
@@ -44,74 +169,954 @@ func (im *InMemoryStorage) AddPost(_ context.Context, userId string, p *post.Pos
 			break
 		}
 	}
+	if p.ConversationId == "" {
+		p.ConversationId = p.Id
+	}
+	if parent != nil {
+		parent.ReplyCount++
+		im.PostIdToReplies[parent.Id] = append(im.PostIdToReplies[parent.Id], p.Id)
+	}
+	if p.RepostOf != "" {
+		im.PostIdToPost[p.RepostOf].Value.(*post.Post).RepostCount++
+		im.PostIdToReposts[p.RepostOf] = append(im.PostIdToReposts[p.RepostOf], p.Id)
+	}
+	if p.QuoteOf != "" {
+		im.PostIdToPost[p.QuoteOf].Value.(*post.Post).QuoteCount++
+	}
+	for _, tag := range p.Tags {
+		im.TagToPostsIds[tag] = append(im.TagToPostsIds[tag], p.Id)
+	}
 	_, ok := im.UserIdToPostsIds[userId]
 	if !ok {
 		im.UserIdToPostsIds[userId] = make([]string, 0)
 	}
 	im.PostIdToIdx[p.Id] = len(im.UserIdToPostsIds[userId])
 	im.UserIdToPostsIds[userId] = append(im.UserIdToPostsIds[userId], p.Id)
-	im.Posts.PushBack(p)
+	stored := *p
+	im.Posts.PushBack(&stored)
 	im.PostIdToPost[p.Id] = im.Posts.Back()
+	im.seq++
+	im.PostIdToSeq[p.Id] = im.seq
+
+	// Fan-out happens synchronously: every subscriber gets the post appended
+	// to the end of their feed, which keeps feeds ordered by creation.
+	recipients := make([]string, 0)
+	for _, subscriber := range im.Subscribers[userId] {
+		if im.inFeedOf(&stored, subscriber) && !im.hasOriginal(im.UserIdToFeed[subscriber], &stored) && !containsString(im.Blocked[userId], subscriber) && im.sharedTo(&stored, subscriber) {
+			im.UserIdToFeed[subscriber] = append(im.UserIdToFeed[subscriber], p.Id)
+			recipients = append(recipients, subscriber)
+		}
+	}
+	p.Original = im.copyPost(&stored).Original
+	im.publish(stream.TypeCreate, &stored, recipients)
+	if parent != nil {
+		im.notify(parent.AuthorId, notification.TypeReply, userId, p.Id)
+	}
+	im.notifyMentions(nil, p.Mentions, p.Id, userId)
+	return nil
 }
 
-func (im *InMemoryStorage) GetPostsByUserId(_ context.Context, userId string, token string, size int) ([]*post.Post, string, error) {
-	arr := make([]*post.Post, 0)
+// resolveMentions keeps the mentions of existing users and fills in both the
+// id and the handle of each of them.
+func (im *InMemoryStorage) resolveMentions(text string) []post.Mention {
+	res := make([]post.Mention, 0)
+	for _, mention := range utils.ExtractMentions(text) {
+		if mention.UserId == "" {
+			mention.UserId = im.HandleToUserId[mention.Handle]
+		}
+		u, ok := im.Users[mention.UserId]
+		if !ok {
+			continue
+		}
+		mention.Handle = u.Handle
+		res = append(res, mention)
+	}
+	return res
+}
+
+func (im *InMemoryStorage) notifyMentions(before []post.Mention, after []post.Mention, postId string, authorId string) {
+	for _, userId := range newMentions(before, after, authorId) {
+		im.notify(userId, notification.TypeMention, authorId, postId)
+	}
+}
+
+// notify creates a notification unless the user is the actor or one of them
+// blocked the other.
+func (im *InMemoryStorage) notify(userId string, typ string, actorId string, postId string) {
+	if im.Notifications == nil || userId == actorId || containsString(im.Blocked[userId], actorId) || containsString(im.Blocked[actorId], userId) {
+		return
+	}
+	_ = im.Notifications.AddNotification(context.Background(), &notification.Notification{
+		UserId:  userId,
+		Type:    typ,
+		ActorId: actorId,
+		PostId:  postId,
+	})
+}
+
+func (im *InMemoryStorage) publish(typ string, p *post.Post, userIds []string) {
+	if im.Broker == nil || len(userIds) == 0 {
+		return
+	}
+	topics := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		topics = append(topics, stream.FeedTopic(userId))
+	}
+	_ = im.Broker.Publish(context.Background(), stream.NewMessage(typ, p.Id, im.copyPost(p)), topics...)
+}
+
+func (im *InMemoryStorage) relation(viewerId string, authorId string) relationship {
+	author := im.Users[authorId]
+	return relationship{
+		follows:   containsString(im.Subscribers[authorId], viewerId),
+		protected: author != nil && author.Protected,
+		blocked:   containsString(im.Blocked[authorId], viewerId),
+		blocking:  containsString(im.Blocked[viewerId], authorId),
+	}
+}
+
+// sharedTo reports whether no block between userId and the author of the post
+// reposted or quoted by p keeps p out of the lists and the feed of userId.
+func (im *InMemoryStorage) sharedTo(p *post.Post, userId string) bool {
+	r, ok := im.originalRelation(p, userId)
+	return !ok || r.sharedListed()
+}
+
+// originalRelation returns the relationship of the viewer with the author of
+// the post reposted or quoted by p. It is false if there is no such post or
+// the viewer is its author.
+func (im *InMemoryStorage) originalRelation(p *post.Post, viewerId string) (relationship, bool) {
+	originalId := p.RepostOf
+	if originalId == "" {
+		originalId = p.QuoteOf
+	}
+	elem, ok := im.PostIdToPost[originalId]
+	if !ok || originalId == "" || elem.Value.(*post.Post).AuthorId == viewerId {
+		return relationship{}, false
+	}
+	return im.relation(viewerId, elem.Value.(*post.Post).AuthorId), true
+}
+
+func (im *InMemoryStorage) visibleTo(p *post.Post, viewerId string) bool {
+	if !im.relation(viewerId, p.AuthorId).visible(p, viewerId) {
+		return false
+	}
+	r, ok := im.originalRelation(p, viewerId)
+	return !ok || viewerId == p.AuthorId || r.sharedVisible()
+}
+
+func (im *InMemoryStorage) listedTo(p *post.Post, viewerId string) bool {
+	return im.relation(viewerId, p.AuthorId).listed(p, viewerId) && im.sharedTo(p, viewerId)
+}
+
+// listedIds returns the posts among ids that belong in a list shown to viewerId.
+func (im *InMemoryStorage) listedIds(ids []string, viewerId string) []string {
+	res := make([]string, 0, len(ids))
+	for _, postId := range ids {
+		if im.listedTo(im.PostIdToPost[postId].Value.(*post.Post), viewerId) {
+			res = append(res, postId)
+		}
+	}
+	return res
+}
+
+func (im *InMemoryStorage) shareable(p *post.Post, userId string) error {
+	return im.relation(userId, p.AuthorId).shareable(p, userId)
+}
+
+// hasOriginal reports whether ids already contain the post reposted by p or
+// another repost of it.
+func (im *InMemoryStorage) hasOriginal(ids []string, p *post.Post) bool {
+	if p.RepostOf == "" {
+		return false
+	}
+	for _, id := range ids {
+		if id == p.RepostOf || im.PostIdToPost[id].Value.(*post.Post).RepostOf == p.RepostOf {
+			return true
+		}
+	}
+	return false
+}
+
+// original returns the post that a repost or a quote of postId refers to.
+// Reposts of reposts are resolved to the post that was reposted first.
+func (im *InMemoryStorage) original(postId string) *post.Post {
+	elem, ok := im.PostIdToPost[postId]
+	if !ok {
+		return nil
+	}
+	p := elem.Value.(*post.Post)
+	if p.RepostOf != "" {
+		return im.original(p.RepostOf)
+	}
+	return p
+}
+
+// copyPost returns a copy of a stored post with the current version of the
+// reposted or quoted post embedded into it.
+func (im *InMemoryStorage) copyPost(p *post.Post) *post.Post {
+	res := *p
+	res.Original = nil
+	originalId := p.RepostOf
+	if originalId == "" {
+		originalId = p.QuoteOf
+	}
+	if elem, ok := im.PostIdToPost[originalId]; ok && originalId != "" {
+		original := *elem.Value.(*post.Post)
+		res.Original = &original
+	}
+	return &res
+}
+
+// inFeedOf reports whether a post of a followed author belongs to the feed of
+// the given user. Replies only do if the user follows the replied-to author too.
+func (im *InMemoryStorage) inFeedOf(p *post.Post, userId string) bool {
+	if !p.VisibleTo(userId, true, false) {
+		return false
+	}
+	replyTo := p.InReplyToAuthorId
+	if replyTo == "" || replyTo == p.AuthorId || replyTo == userId {
+		return true
+	}
+	return containsString(im.Subscriptions[userId], replyTo)
+}
+
+func (im *InMemoryStorage) GetPostsByUserId(_ context.Context, viewerId string, userId string, before string, since string, size int) (*Page, error) {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
-	_, ok := im.UserIdToPostsIds[userId]
+	ids := im.UserIdToPostsIds[userId]
+	if viewerId != userId {
+		r := im.relation(viewerId, userId)
+		if r.blocked {
+			return nil, ErrBlocked
+		}
+		if r.protected && !r.follows {
+			return nil, ErrForbiddenAccess
+		}
+		visible := make([]string, 0, len(ids))
+		for _, postId := range ids {
+			p := im.PostIdToPost[postId].Value.(*post.Post)
+			if r.visible(p, viewerId) && im.sharedTo(p, viewerId) {
+				visible = append(visible, postId)
+			}
+		}
+		ids = visible
+	}
+	return im.seqPage(postsOwner(userId), ids, before, since, size)
+}
+
+func (im *InMemoryStorage) ModifyPost(_ context.Context, userId string, postId string, newPost *post.Post) (*post.Post, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	elem, ok := im.PostIdToPost[postId]
+	if !ok {
+		return nil, ErrPostNotFound
+	}
+	p := elem.Value.(*post.Post)
+	if p.AuthorId != userId || p.RepostOf != "" {
+		return nil, ErrForbiddenAccess
+	}
+	p.Text = newPost.Text
+	p.LastModifiedAt = utils.GetCurrentTimestamp()
+	tags := utils.ExtractTags(p.Text)
+	for _, tag := range p.Tags {
+		if !containsString(tags, tag) {
+			im.TagToPostsIds[tag] = removeString(im.TagToPostsIds[tag], postId)
+		}
+	}
+	for _, tag := range tags {
+		if containsString(p.Tags, tag) {
+			continue
+		}
+		ids := append(im.TagToPostsIds[tag], postId)
+		sort.Slice(ids, func(i, j int) bool {
+			return im.PostIdToSeq[ids[i]] < im.PostIdToSeq[ids[j]]
+		})
+		im.TagToPostsIds[tag] = ids
+	}
+	p.Tags = tags
+	mentions := im.resolveMentions(p.Text)
+	im.notifyMentions(p.Mentions, mentions, postId, userId)
+	p.Mentions = mentions
+	recipients := make([]string, 0)
+	for _, subscriber := range im.Subscribers[userId] {
+		if containsString(im.UserIdToFeed[subscriber], postId) {
+			recipients = append(recipients, subscriber)
+		}
+	}
+	im.publish(stream.TypeModify, p, recipients)
+	return im.copyPost(p), nil
+}
+
+func (im *InMemoryStorage) DeletePost(_ context.Context, userId string, postId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	elem, ok := im.PostIdToPost[postId]
 	if !ok {
-		if token != "" {
-			return arr, "", ErrParseToken
+		return ErrPostNotFound
+	}
+	if elem.Value.(*post.Post).AuthorId != userId {
+		return ErrForbiddenAccess
+	}
+	im.deletePost(elem)
+	return nil
+}
+
+// deletePost removes a post from every index and feed, together with all of
+// its reposts.
+func (im *InMemoryStorage) deletePost(elem *list.Element) {
+	p := elem.Value.(*post.Post)
+	postId := p.Id
+	userId := p.AuthorId
+	for _, repostId := range im.PostIdToReposts[postId] {
+		im.deletePost(im.PostIdToPost[repostId])
+	}
+	delete(im.PostIdToReposts, postId)
+	for _, liker := range im.PostIdToLikes[postId] {
+		im.UserIdToLikes[liker] = removeString(im.UserIdToLikes[liker], postId)
+	}
+	delete(im.PostIdToLikes, postId)
+	for _, tag := range p.Tags {
+		im.TagToPostsIds[tag] = removeString(im.TagToPostsIds[tag], postId)
+	}
+	if original, ok := im.PostIdToPost[p.RepostOf]; ok {
+		original.Value.(*post.Post).RepostCount--
+		im.PostIdToReposts[p.RepostOf] = removeString(im.PostIdToReposts[p.RepostOf], postId)
+	}
+	if original, ok := im.PostIdToPost[p.QuoteOf]; ok {
+		original.Value.(*post.Post).QuoteCount--
+	}
+	if parent, ok := im.PostIdToPost[p.InReplyTo]; ok {
+		parent.Value.(*post.Post).ReplyCount--
+		im.PostIdToReplies[p.InReplyTo] = removeString(im.PostIdToReplies[p.InReplyTo], postId)
+	}
+	im.Posts.Remove(elem)
+	delete(im.PostIdToPost, postId)
+	delete(im.PostIdToSeq, postId)
+
+	idx := im.PostIdToIdx[postId]
+	delete(im.PostIdToIdx, postId)
+	postsIds := im.UserIdToPostsIds[userId]
+	postsIds = append(postsIds[:idx], postsIds[idx+1:]...)
+	for i := idx; i < len(postsIds); i++ {
+		im.PostIdToIdx[postsIds[i]] = i
+	}
+	im.UserIdToPostsIds[userId] = postsIds
+
+	for _, subscriber := range im.Subscribers[userId] {
+		im.UserIdToFeed[subscriber] = removeString(im.UserIdToFeed[subscriber], postId)
+	}
+}
+
+func (im *InMemoryStorage) GetPostsByTag(_ context.Context, viewerId string, tag string, token string, size int) ([]*post.Post, string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return olderPage(im.seqPage(tagOwner(tag), im.listedIds(im.TagToPostsIds[tag], viewerId), token, "", size))
+}
+
+func (im *InMemoryStorage) GetReplies(_ context.Context, viewerId string, postId string, token string, size int) ([]*post.Post, string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return olderPage(im.seqPage(repliesOwner(postId), im.listedIds(im.PostIdToReplies[postId], viewerId), token, "", size))
+}
+
+func (im *InMemoryStorage) GetThread(_ context.Context, viewerId string, postId string) ([]*post.Post, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	elem, ok := im.PostIdToPost[postId]
+	if !ok || !im.listedTo(elem.Value.(*post.Post), viewerId) {
+		return nil, ErrPostNotFound
+	}
+	thread := make([]*post.Post, 0)
+	for len(thread) <= MaxThreadDepth {
+		p := im.copyPost(elem.Value.(*post.Post))
+		thread = append(thread, p)
+		elem, ok = im.PostIdToPost[p.InReplyTo]
+		if !ok || !im.listedTo(elem.Value.(*post.Post), viewerId) {
+			break
+		}
+	}
+	reversePosts(thread)
+	return thread, nil
+}
+
+func (im *InMemoryStorage) GetConversationMembers(_ context.Context, viewerId string, conversationId string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	elem, ok := im.PostIdToPost[conversationId]
+	if !ok || !im.visibleTo(elem.Value.(*post.Post), viewerId) {
+		return nil, ErrPostNotFound
+	}
+	// Every post of the conversation is reachable from the root by replies.
+	members := make([]string, 0)
+	queue := []string{conversationId}
+	for len(queue) != 0 {
+		elem, ok = im.PostIdToPost[queue[0]]
+		queue = append(queue[1:], im.PostIdToReplies[queue[0]]...)
+		if ok && !containsString(members, elem.Value.(*post.Post).AuthorId) {
+			members = append(members, elem.Value.(*post.Post).AuthorId)
+		}
+	}
+	return members, nil
+}
+
+func (im *InMemoryStorage) Subscribe(_ context.Context, subscribee string, subscriber string) error {
+	if subscribee == subscriber {
+		return ErrInvalidSubscribe
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if im.Users[subscribee] == nil || im.Users[subscriber] == nil {
+		return ErrUserNotFound
+	}
+	if containsString(im.Blocked[subscribee], subscriber) || containsString(im.Blocked[subscriber], subscribee) {
+		return ErrBlocked
+	}
+	if containsString(im.Subscribers[subscribee], subscriber) {
+		return nil
+	}
+	if im.Users[subscribee].Protected {
+		if !containsString(im.FollowRequests[subscribee], subscriber) {
+			im.FollowRequests[subscribee] = append(im.FollowRequests[subscribee], subscriber)
+			im.notify(subscribee, notification.TypeFollowRequest, subscriber, "")
+		}
+		return ErrFollowRequested
+	}
+	im.subscribe(subscribee, subscriber)
+	return nil
+}
+
+func (im *InMemoryStorage) subscribe(subscribee string, subscriber string) {
+	im.Subscribers[subscribee] = append(im.Subscribers[subscribee], subscriber)
+	im.Subscriptions[subscriber] = append(im.Subscriptions[subscriber], subscribee)
+
+	// Backfill the subscriber's feed with the author's existing posts and
+	// restore the creation order afterwards.
+	fd := im.UserIdToFeed[subscriber]
+	for _, postId := range im.UserIdToPostsIds[subscribee] {
+		p := im.PostIdToPost[postId].Value.(*post.Post)
+		if im.inFeedOf(p, subscriber) && !containsString(fd, postId) && !im.hasOriginal(fd, p) {
+			fd = append(fd, postId)
+		}
+	}
+	sort.Slice(fd, func(i, j int) bool {
+		return im.PostIdToSeq[fd[i]] < im.PostIdToSeq[fd[j]]
+	})
+	im.UserIdToFeed[subscriber] = fd
+	im.notify(subscribee, notification.TypeFollow, subscriber, "")
+}
+
+func (im *InMemoryStorage) Unsubscribe(_ context.Context, subscribee string, subscriber string) error {
+	if subscribee == subscriber {
+		return ErrInvalidSubscribe
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.unsubscribe(subscribee, subscriber)
+	return nil
+}
+
+func (im *InMemoryStorage) unsubscribe(subscribee string, subscriber string) {
+	im.FollowRequests[subscribee] = removeString(im.FollowRequests[subscribee], subscriber)
+	if !containsString(im.Subscribers[subscribee], subscriber) {
+		return
+	}
+	im.Subscribers[subscribee] = removeString(im.Subscribers[subscribee], subscriber)
+	im.Subscriptions[subscriber] = removeString(im.Subscriptions[subscriber], subscribee)
+
+	// Replies to the author go away as well, since the subscriber no longer
+	// follows the conversation.
+	fd := make([]string, 0, len(im.UserIdToFeed[subscriber]))
+	for _, postId := range im.UserIdToFeed[subscriber] {
+		p := im.PostIdToPost[postId].Value.(*post.Post)
+		if p.AuthorId != subscribee && im.inFeedOf(p, subscriber) {
+			fd = append(fd, postId)
+		}
+	}
+	im.UserIdToFeed[subscriber] = fd
+}
+
+func (im *InMemoryStorage) GetSubscribers(_ context.Context, userId string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return append([]string{}, im.Subscribers[userId]...), nil
+}
+
+func (im *InMemoryStorage) GetSubscriptions(_ context.Context, userId string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return append([]string{}, im.Subscriptions[userId]...), nil
+}
+
+func (im *InMemoryStorage) GetFollowRequests(_ context.Context, userId string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return append([]string{}, im.FollowRequests[userId]...), nil
+}
+
+func (im *InMemoryStorage) ApproveFollowRequest(_ context.Context, userId string, subscriber string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if !containsString(im.FollowRequests[userId], subscriber) {
+		return ErrFollowRequestNotFound
+	}
+	im.FollowRequests[userId] = removeString(im.FollowRequests[userId], subscriber)
+	im.subscribe(userId, subscriber)
+	return nil
+}
+
+func (im *InMemoryStorage) RejectFollowRequest(_ context.Context, userId string, subscriber string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if !containsString(im.FollowRequests[userId], subscriber) {
+		return ErrFollowRequestNotFound
+	}
+	im.FollowRequests[userId] = removeString(im.FollowRequests[userId], subscriber)
+	return nil
+}
+
+func (im *InMemoryStorage) Block(_ context.Context, userId string, blockedId string) error {
+	if userId == blockedId {
+		return ErrInvalidBlock
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if im.Users[blockedId] == nil {
+		return ErrUserNotFound
+	}
+	if !containsString(im.Blocked[userId], blockedId) {
+		im.Blocked[userId] = append(im.Blocked[userId], blockedId)
+	}
+	im.unsubscribe(userId, blockedId)
+	im.unsubscribe(blockedId, userId)
+	return nil
+}
+
+func (im *InMemoryStorage) Unblock(_ context.Context, userId string, blockedId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.Blocked[userId] = removeString(im.Blocked[userId], blockedId)
+	return nil
+}
+
+func (im *InMemoryStorage) GetBlocked(_ context.Context, userId string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return append([]string{}, im.Blocked[userId]...), nil
+}
+
+func (im *InMemoryStorage) GetBlockedWith(_ context.Context, userId string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	blocked := append([]string{}, im.Blocked[userId]...)
+	for blocker, blockedByBlocker := range im.Blocked {
+		if containsString(blockedByBlocker, userId) && !containsString(blocked, blocker) {
+			blocked = append(blocked, blocker)
 		}
-		return arr, "", nil
 	}
-	start := len(im.UserIdToPostsIds[userId]) - 1
-	if token != "" {
-		SizeAndPostId := strings.SplitN(token, "-", 2)
-		if len(SizeAndPostId) != 2 {
-			return arr, "", ErrParseToken
+	return blocked, nil
+}
+
+func (im *InMemoryStorage) Mute(_ context.Context, userId string, mutedId string) error {
+	if userId == mutedId {
+		return ErrInvalidBlock
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if im.Users[mutedId] == nil {
+		return ErrUserNotFound
+	}
+	if !containsString(im.Muted[userId], mutedId) {
+		im.Muted[userId] = append(im.Muted[userId], mutedId)
+	}
+	return nil
+}
+
+func (im *InMemoryStorage) Unmute(_ context.Context, userId string, mutedId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.Muted[userId] = removeString(im.Muted[userId], mutedId)
+	return nil
+}
+
+func (im *InMemoryStorage) GetMuted(_ context.Context, userId string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return append([]string{}, im.Muted[userId]...), nil
+}
+
+func (im *InMemoryStorage) GetFeed(_ context.Context, userId string, before string, since string, size int) (*Page, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	ff := utils.NewFeedFilter(im.activeFilters(userId))
+	fd := im.feedOf(userId)
+	if ff != nil {
+		shown := make([]string, 0, len(fd))
+		for _, postId := range fd {
+			if !ff.Hides(im.copyPost(im.PostIdToPost[postId].Value.(*post.Post))) {
+				shown = append(shown, postId)
+			}
+		}
+		fd = shown
+	}
+	return im.seqPage(feedOwner(userId), fd, before, since, size)
+}
+
+// feedOf returns the feed of the user without the posts of muted authors, the
+// reposts and quotes of their posts and of the posts of the authors the user
+// has a block with.
+func (im *InMemoryStorage) feedOf(userId string) []string {
+	fd := im.UserIdToFeed[userId]
+	res := make([]string, 0, len(fd))
+	for _, postId := range fd {
+		p := im.PostIdToPost[postId].Value.(*post.Post)
+		if !MutedIn(im.copyPost(p), im.Muted[userId]) && im.sharedTo(p, userId) {
+			res = append(res, postId)
 		}
-		if size == DEFAULT {
-			size, _ = strconv.Atoi(SizeAndPostId[0])
+	}
+	return res
+}
+
+func (im *InMemoryStorage) AddFilter(_ context.Context, userId string, f *filter.Filter) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if len(im.activeFilters(userId)) >= MaxFilters {
+		return ErrTooManyFilters
+	}
+	f.ID = primitive.NewObjectID()
+	f.UserId = userId
+	f.CreatedAt = utils.GetCurrentTimestamp()
+	stored := *f
+	im.Filters[userId] = append(im.activeFilters(userId), &stored)
+	return nil
+}
+
+func (im *InMemoryStorage) GetFilters(_ context.Context, userId string) ([]*filter.Filter, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	filters := make([]*filter.Filter, 0)
+	for _, f := range im.activeFilters(userId) {
+		res := *f
+		filters = append(filters, &res)
+	}
+	return filters, nil
+}
+
+func (im *InMemoryStorage) DeleteFilter(_ context.Context, userId string, filterId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	filters := im.Filters[userId]
+	for i, f := range filters {
+		if f.ID.Hex() == filterId {
+			im.Filters[userId] = append(filters[:i:i], filters[i+1:]...)
+			return nil
 		}
-		postId := SizeAndPostId[1]
-		elem, ok := im.PostIdToPost[postId]
-		p := elem.Value.(*post.Post)
-		if !ok || p.AuthorId != userId {
-			return arr, "", ErrParseToken
+	}
+	return ErrFilterNotFound
+}
+
+// activeFilters returns the filters of the user that have not expired.
+func (im *InMemoryStorage) activeFilters(userId string) []*filter.Filter {
+	now := time.Now()
+	res := make([]*filter.Filter, 0, len(im.Filters[userId]))
+	for _, f := range im.Filters[userId] {
+		if f.Active(now) {
+			res = append(res, f)
 		}
-		start = im.PostIdToIdx[postId] - 1
+	}
+	return res
+}
+
+func (im *InMemoryStorage) GetFeedSince(_ context.Context, userId string, oid string, size int) ([]feed.Entry, error) {
+	entries := make([]feed.Entry, 0)
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	seq, ok := im.PostIdToSeq[oid]
+	if !ok {
+		return entries, ErrParseToken
+	}
+	fd := im.feedOf(userId)
+	start := sort.Search(len(fd), func(i int) bool {
+		return im.PostIdToSeq[fd[i]] > seq
+	})
+	for i := start; i < len(fd) && len(entries) < size; i++ {
+		p := im.copyPost(im.PostIdToPost[fd[i]].Value.(*post.Post))
+		entries = append(entries, feed.Entry{Oid: fd[i], Post: p})
+	}
+	return entries, nil
+}
+
+func (im *InMemoryStorage) Like(_ context.Context, userId string, postId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.Users[userId]; !ok {
+		return ErrUserNotFound
+	}
+	original := im.original(postId)
+	if original == nil || !im.visibleTo(original, userId) {
+		return ErrPostNotFound
+	}
+	if containsString(im.PostIdToLikes[original.Id], userId) {
+		return nil
+	}
+	im.PostIdToLikes[original.Id] = append(im.PostIdToLikes[original.Id], userId)
+	im.UserIdToLikes[userId] = append(im.UserIdToLikes[userId], original.Id)
+	original.LikeCount++
+	im.notify(original.AuthorId, notification.TypeLike, userId, original.Id)
+	return nil
+}
+
+func (im *InMemoryStorage) Unlike(_ context.Context, userId string, postId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	original := im.original(postId)
+	if original == nil {
+		return ErrPostNotFound
+	}
+	if !containsString(im.PostIdToLikes[original.Id], userId) {
+		return nil
+	}
+	im.PostIdToLikes[original.Id] = removeString(im.PostIdToLikes[original.Id], userId)
+	im.UserIdToLikes[userId] = removeString(im.UserIdToLikes[userId], original.Id)
+	original.LikeCount--
+	return nil
+}
+
+func (im *InMemoryStorage) GetLikes(_ context.Context, postId string, token string, size int) ([]string, string, error) {
+	res := make([]string, 0)
+	owner := likesOwner(postId)
+	key, size, err := im.Pages.Decode(owner, token, size)
+	if err != nil {
+		return res, "", err
 	}
 	if size == DEFAULT {
 		size = 10
 	}
-	end := start - size
-	strSize := strconv.Itoa(size)
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	// Likes of a repost are stored on the original, see Like.
+	original := im.original(postId)
+	if original == nil {
+		return res, "", ErrPostNotFound
+	}
+	likes := im.PostIdToLikes[original.Id]
+	start, err := idStart(likes, key)
+	if err != nil {
+		return res, "", err
+	}
+	for i := start; i >= 0 && len(res) < size; i-- {
+		res = append(res, likes[i])
+	}
 	retToken := ""
-	if end >= 0 {
-		retToken = strSize + "-" + im.UserIdToPostsIds[userId][end+1]
-	} else {
-		end = -1
+	if start-size >= 0 {
+		retToken = im.Pages.Encode(owner, res[len(res)-1], size)
 	}
-	for start > end {
-		arr = append(arr, im.PostIdToPost[im.UserIdToPostsIds[userId][start]].Value.(*post.Post))
-		start--
+	return res, retToken, nil
+}
+
+func (im *InMemoryStorage) GetLikedPosts(_ context.Context, viewerId string, userId string, token string, size int) ([]*post.Post, string, error) {
+	owner := likedOwner(userId)
+	key, size, err := im.Pages.Decode(owner, token, size)
+	if err != nil {
+		return make([]*post.Post, 0), "", err
+	}
+	if size == DEFAULT {
+		size = 10
+	}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	if viewerId != userId {
+		r := im.relation(viewerId, userId)
+		if r.blocked {
+			return make([]*post.Post, 0), "", ErrBlocked
+		}
+		if r.protected && !r.follows {
+			return make([]*post.Post, 0), "", ErrForbiddenAccess
+		}
+	}
+	likes := im.UserIdToLikes[userId]
+	start, err := idStart(likes, key)
+	if err != nil {
+		return make([]*post.Post, 0), "", err
+	}
+	arr, last := im.page(likes, start, size)
+	visible := make([]*post.Post, 0, len(arr))
+	for _, p := range arr {
+		if im.listedTo(p, viewerId) {
+			visible = append(visible, p)
+		}
+	}
+	if last < 0 {
+		return visible, "", nil
 	}
-	return arr, retToken, nil
+	return visible, im.Pages.Encode(owner, likes[last], size), nil
 }
 
-func (im *InMemoryStorage) ModifyPost(_ context.Context, userId string, postId string, newPost *post.Post) (*post.Post, error) {
+func (im *InMemoryStorage) AddUser(_ context.Context, u *user.User) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.Users[u.Id]; ok {
+		return ErrUserExists
+	}
+	if _, ok := im.HandleToUserId[u.Handle]; ok {
+		return ErrHandleTaken
+	}
+	u.CreatedAt = utils.GetCurrentTimestamp()
+	stored := *u
+	im.Users[u.Id] = &stored
+	im.HandleToUserId[u.Handle] = u.Id
+	return nil
+}
+
+func (im *InMemoryStorage) GetUserById(_ context.Context, userId string) (*user.User, error) {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
-	elem, ok := im.PostIdToPost[postId]
+	u, ok := im.Users[userId]
 	if !ok {
-		return nil, ErrPostNotFound
+		return nil, ErrUserNotFound
 	}
-	p := elem.Value.(*post.Post)
-	if p.AuthorId != userId {
-		return nil, ErrForbiddenAccess
+	res := *u
+	return &res, nil
+}
+
+func (im *InMemoryStorage) GetUserByHandle(ctx context.Context, handle string) (*user.User, error) {
+	im.mu.RLock()
+	userId, ok := im.HandleToUserId[handle]
+	im.mu.RUnlock()
+	if !ok {
+		return nil, ErrUserNotFound
 	}
-	p.Text = newPost.Text
-	p.LastModifiedAt = utils.GetCurrentTimestamp()
-	return p, nil
+	return im.GetUserById(ctx, userId)
+}
+
+func (im *InMemoryStorage) ModifyUser(_ context.Context, userId string, patch *user.Patch) (*user.User, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	u, ok := im.Users[userId]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if patch.Handle != nil && *patch.Handle != u.Handle {
+		if _, ok := im.HandleToUserId[*patch.Handle]; ok {
+			return nil, ErrHandleTaken
+		}
+		delete(im.HandleToUserId, u.Handle)
+		im.HandleToUserId[*patch.Handle] = userId
+	}
+	u.Apply(patch)
+	if patch.Protected != nil && !*patch.Protected {
+		// Nobody has to approve the followers of an open account.
+		for _, subscriber := range im.FollowRequests[userId] {
+			im.subscribe(userId, subscriber)
+		}
+		delete(im.FollowRequests, userId)
+	}
+	res := *u
+	return &res, nil
+}
+
+// page walks ids backwards from start and returns at most size posts together
+// with the index of the last of them, or -1 if nothing is left after it.
+func (im *InMemoryStorage) page(ids []string, start int, size int) ([]*post.Post, int) {
+	arr := make([]*post.Post, 0)
+	end := start - size
+	last := end + 1
+	if end < 0 {
+		end = -1
+		last = -1
+	}
+	for start > end {
+		arr = append(arr, im.copyPost(im.PostIdToPost[ids[start]].Value.(*post.Post)))
+		start--
+	}
+	return arr, last
+}
+
+// seqPage returns a page of ids ordered by seq, newest first. Its cursors keep
+// the seq of a post of the page, so they stay valid after that post is
+// deleted.
+func (im *InMemoryStorage) seqPage(owner string, ids []string, before string, since string, size int) (*Page, error) {
+	c, err := im.Pages.decodeCursor(owner, before, since, size)
+	if err != nil {
+		return nil, err
+	}
+	seq := 0
+	if c.key != "" {
+		seq, err = strconv.Atoi(c.key)
+		if err != nil {
+			return nil, ErrParseToken
+		}
+	}
+	// ids[start:end] is the page, oldest first.
+	var start, end int
+	hasMore := false
+	if c.forward {
+		start = sort.Search(len(ids), func(i int) bool {
+			return im.PostIdToSeq[ids[i]] > seq
+		})
+		end = start + c.size
+		hasMore = end < len(ids)
+		if !hasMore {
+			end = len(ids)
+		}
+	} else {
+		end = len(ids)
+		if c.key != "" {
+			end = sort.Search(len(ids), func(i int) bool {
+				return im.PostIdToSeq[ids[i]] >= seq
+			})
+		}
+		start = end - c.size
+		hasMore = start > 0
+		if !hasMore {
+			start = 0
+		}
+	}
+	posts := make([]*post.Post, 0, end-start)
+	keys := make([]string, 0, end-start)
+	for i := end - 1; i >= start; i-- {
+		posts = append(posts, im.copyPost(im.PostIdToPost[ids[i]].Value.(*post.Post)))
+		keys = append(keys, strconv.Itoa(im.PostIdToSeq[ids[i]]))
+	}
+	return im.Pages.page(c, posts, keys, hasMore), nil
+}
+
+// idStart returns the index in ids to start a page from, walking backwards,
+// for lists that are not ordered by seq. Their tokens keep the id of the last
+// item of the page.
+func idStart(ids []string, key string) (int, error) {
+	if key == "" {
+		return len(ids) - 1, nil
+	}
+	for i, id := range ids {
+		if id == key {
+			return i - 1, nil
+		}
+	}
+	return 0, ErrParseToken
+}
+
+func reversePosts(arr []*post.Post) {
+	for i, j := 0, len(arr)-1; i < j; i, j = i+1, j-1 {
+		arr[i], arr[j] = arr[j], arr[i]
+	}
+}
+
+func containsString(arr []string, s string) bool {
+	for _, elem := range arr {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(arr []string, s string) []string {
+	res := make([]string, 0, len(arr))
+	for _, elem := range arr {
+		if elem != s {
+			res = append(res, elem)
+		}
+	}
+	return res
 }
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"context"
+	"mini-twitter/domain/post"
+	"mini-twitter/domain/user"
+	"testing"
+)
+
+func TestInMemoryVisibility(t *testing.T) {
+	tests := []struct {
+		name       string
+		visibility string
+		text       string
+		protected  bool
+		follows    bool
+		blocked    bool
+		viewer     string
+		want       error
+	}{
+		{name: "public", visibility: post.VisibilityPublic, viewer: bob},
+		{name: "anonymous", visibility: post.VisibilityPublic, viewer: ""},
+		{name: "followers, not following", visibility: post.VisibilityFollowers, viewer: bob, want: ErrPostNotFound},
+		{name: "followers, following", visibility: post.VisibilityFollowers, follows: true, viewer: bob},
+		{name: "mentioned, not mentioned", visibility: post.VisibilityMentioned, text: "hi @carol", viewer: bob, want: ErrPostNotFound},
+		{name: "mentioned, mentioned", visibility: post.VisibilityMentioned, text: "hi @bob", viewer: bob},
+		{name: "protected, not following", visibility: post.VisibilityPublic, protected: true, viewer: bob, want: ErrPostNotFound},
+		{name: "protected, following", visibility: post.VisibilityPublic, protected: true, follows: true, viewer: bob},
+		{name: "blocked", visibility: post.VisibilityPublic, blocked: true, viewer: bob, want: ErrPostNotFound},
+		{name: "author", visibility: post.VisibilityMentioned, protected: true, viewer: alice},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			im := newTestStorage(t)
+			if tt.follows {
+				_ = im.Subscribe(ctx, alice, bob)
+			}
+			if tt.protected {
+				protected := true
+				_, _ = im.ModifyUser(ctx, alice, &user.Patch{Protected: &protected})
+			}
+			if tt.blocked {
+				_ = im.Block(ctx, alice, bob)
+			}
+			postId := addTestPost(t, im, alice, &post.Post{Text: tt.text, Visibility: tt.visibility})
+			_, err := im.GetPostById(ctx, tt.viewer, postId)
+			if err != tt.want {
+				t.Errorf("GetPostById() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemoryBlocks(t *testing.T) {
+	ctx := context.Background()
+	im := newTestStorage(t)
+	_ = im.Subscribe(ctx, alice, bob)
+	_ = im.Subscribe(ctx, bob, alice)
+	alicePost := addTestPost(t, im, alice, &post.Post{Text: "#news from alice"})
+	addTestPost(t, im, bob, &post.Post{Text: "#news from bob"})
+	err := im.Block(ctx, alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, _ := im.GetSubscriptions(ctx, bob)
+	if len(subscriptions) != 0 {
+		t.Errorf("subscriptions of the blocked user = %v, want none", subscriptions)
+	}
+	if err := im.Subscribe(ctx, alice, bob); err != ErrBlocked {
+		t.Errorf("Subscribe() by the blocked user error = %v, want %v", err, ErrBlocked)
+	}
+	if err := im.Subscribe(ctx, bob, alice); err != ErrBlocked {
+		t.Errorf("Subscribe() by the blocker error = %v, want %v", err, ErrBlocked)
+	}
+	if _, err := im.GetPostsByUserId(ctx, bob, alice, "", "", DEFAULT); err != ErrBlocked {
+		t.Errorf("GetPostsByUserId() error = %v, want %v", err, ErrBlocked)
+	}
+	if err := im.Like(ctx, bob, alicePost); err != ErrPostNotFound {
+		t.Errorf("Like() error = %v, want %v", err, ErrPostNotFound)
+	}
+
+	tests := []struct {
+		name   string
+		viewer string
+		want   []string
+	}{
+		{name: "blocker", viewer: alice, want: []string{"#news from alice"}},
+		{name: "blocked", viewer: bob, want: []string{"#news from bob"}},
+		{name: "other", viewer: carol, want: []string{"#news from bob", "#news from alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posts, _, err := im.GetPostsByTag(ctx, tt.viewer, "news", "", DEFAULT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			texts := make([]string, 0, len(posts))
+			for _, p := range posts {
+				texts = append(texts, p.Text)
+			}
+			if !equalStrings(texts, tt.want) {
+				t.Errorf("GetPostsByTag() = %v, want %v", texts, tt.want)
+			}
+		})
+	}
+
+	before, _ := im.Notifications.GetUnreadCount(ctx, alice)
+	addTestPost(t, im, bob, &post.Post{Text: "hi @alice"})
+	after, _ := im.Notifications.GetUnreadCount(ctx, alice)
+	if after != before {
+		t.Errorf("the blocker got %d notifications from the blocked user", after-before)
+	}
+}
+
+func TestInMemoryLikes(t *testing.T) {
+	ctx := context.Background()
+	im := newTestStorage(t)
+	postId := addTestPost(t, im, alice, &post.Post{Text: "hello"})
+	repost, err := im.Repost(ctx, bob, postId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		op        func() error
+		wantCount int
+		wantLikes []string
+	}{
+		{name: "like", op: func() error { return im.Like(ctx, bob, postId) }, wantCount: 1, wantLikes: []string{bob}},
+		{name: "like again", op: func() error { return im.Like(ctx, bob, postId) }, wantCount: 1, wantLikes: []string{bob}},
+		{name: "like a repost", op: func() error { return im.Like(ctx, carol, repost.Id) }, wantCount: 2, wantLikes: []string{carol, bob}},
+		{name: "unlike", op: func() error { return im.Unlike(ctx, bob, postId) }, wantCount: 1, wantLikes: []string{carol}},
+		{name: "unlike again", op: func() error { return im.Unlike(ctx, bob, postId) }, wantCount: 1, wantLikes: []string{carol}},
+		{name: "unlike a repost", op: func() error { return im.Unlike(ctx, carol, repost.Id) }, wantCount: 0, wantLikes: []string{}},
+	}
+	// The cases run in order and share the storage.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); err != nil {
+				t.Fatal(err)
+			}
+			p, err := im.GetPostById(ctx, bob, postId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.LikeCount != tt.wantCount {
+				t.Errorf("LikeCount = %d, want %d", p.LikeCount, tt.wantCount)
+			}
+			likes, _, err := im.GetLikes(ctx, repost.Id, "", DEFAULT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStrings(likes, tt.wantLikes) {
+				t.Errorf("GetLikes() = %v, want %v", likes, tt.wantLikes)
+			}
+		})
+	}
+	if err := im.Like(ctx, bob, "ffffffffffffffffffffffff"); err != ErrPostNotFound {
+		t.Errorf("Like() of a missing post error = %v, want %v", err, ErrPostNotFound)
+	}
+}
+
+func TestInMemoryPagination(t *testing.T) {
+	ctx := context.Background()
+	im := newTestStorage(t)
+	for _, text := range []string{"1", "2", "3", "4", "5"} {
+		addTestPost(t, im, alice, &post.Post{Text: text})
+	}
+	texts := func(p *Page) []string {
+		res := make([]string, 0, len(p.Posts))
+		for _, item := range p.Posts {
+			res = append(res, item.Text)
+		}
+		return res
+	}
+
+	first, err := im.GetPostsByUserId(ctx, bob, alice, "", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := im.GetPostsByUserId(ctx, bob, alice, first.Before, "", DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := im.GetPostsByUserId(ctx, bob, alice, second.Before, "", DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestPost(t, im, alice, &post.Post{Text: "6"})
+	newer, err := im.GetPostsByUserId(ctx, bob, alice, "", first.Since, DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none, err := im.GetPostsByUserId(ctx, bob, alice, "", newer.Since, DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		page        *Page
+		want        []string
+		wantHasMore bool
+	}{
+		{name: "first", page: first, want: []string{"5", "4"}, wantHasMore: true},
+		{name: "second", page: second, want: []string{"3", "2"}, wantHasMore: true},
+		{name: "last", page: last, want: []string{"1"}},
+		{name: "newer", page: newer, want: []string{"6"}},
+		{name: "nothing newer", page: none, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := texts(tt.page); !equalStrings(got, tt.want) || tt.page.HasMore != tt.wantHasMore {
+				t.Errorf("page = %v, hasMore %v, want %v, %v", got, tt.page.HasMore, tt.want, tt.wantHasMore)
+			}
+		})
+	}
+	if none.Since != newer.Since {
+		t.Errorf("an empty since page moved the cursor")
+	}
+	if _, err := im.GetPostsByUserId(ctx, bob, alice, first.Before, first.Since, DEFAULT); err != ErrParseToken {
+		t.Errorf("GetPostsByUserId() with both cursors error = %v, want %v", err, ErrParseToken)
+	}
+	if _, err := im.GetPostsByUserId(ctx, bob, bob, first.Before, "", DEFAULT); err != ErrParseToken {
+		t.Errorf("GetPostsByUserId() with a cursor of another list error = %v, want %v", err, ErrParseToken)
+	}
+}
+
+func TestInMemoryRepostsOfBlocker(t *testing.T) {
+	ctx := context.Background()
+	im := newTestStorage(t)
+	_ = im.Subscribe(ctx, alice, bob)
+	original := addTestPost(t, im, carol, &post.Post{Text: "from carol"})
+	err := im.Block(ctx, carol, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repost, err := im.Repost(ctx, alice, original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote := addTestPost(t, im, alice, &post.Post{Text: "look", QuoteOf: original})
+
+	for _, postId := range []string{repost.Id, quote} {
+		if _, err := im.GetPostById(ctx, bob, postId); err != ErrPostNotFound {
+			t.Errorf("GetPostById() error = %v, want %v", err, ErrPostNotFound)
+		}
+		if _, err := im.GetPostById(ctx, alice, postId); err != nil {
+			t.Errorf("GetPostById() by the author error = %v", err)
+		}
+	}
+	posts, err := im.GetPostsByUserId(ctx, bob, alice, "", "", DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts.Posts) != 0 {
+		t.Errorf("GetPostsByUserId() = %d posts, want none", len(posts.Posts))
+	}
+	feed, err := im.GetFeed(ctx, bob, "", "", DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Posts) != 0 {
+		t.Errorf("GetFeed() = %d posts, want none", len(feed.Posts))
+	}
+}
+
+func TestInMemoryOpenAccount(t *testing.T) {
+	ctx := context.Background()
+	im := newTestStorage(t)
+	protected := true
+	_, _ = im.ModifyUser(ctx, alice, &user.Patch{Protected: &protected})
+	if err := im.Subscribe(ctx, alice, bob); err != ErrFollowRequested {
+		t.Fatalf("Subscribe() error = %v, want %v", err, ErrFollowRequested)
+	}
+	protected = false
+	_, err := im.ModifyUser(ctx, alice, &user.Patch{Protected: &protected})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, _ := im.GetFollowRequests(ctx, alice)
+	if len(requests) != 0 {
+		t.Errorf("follow requests = %v, want none", requests)
+	}
+	subscribers, _ := im.GetSubscribers(ctx, alice)
+	if !equalStrings(subscribers, []string{bob}) {
+		t.Errorf("subscribers = %v, want [%s]", subscribers, bob)
+	}
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"mini-twitter/dispatcher"
+	"mini-twitter/domain/notification"
+	"mini-twitter/domain/post"
+)
+
+// mentionEvents notifies the users newly mentioned in a post.
+func mentionEvents(before []post.Mention, after []post.Mention, postId string, authorId string) []dispatcher.Event {
+	events := make([]dispatcher.Event, 0)
+	for _, userId := range newMentions(before, after, authorId) {
+		events = append(events, dispatcher.Notified{UserId: userId, Type: notification.TypeMention, ActorId: authorId, PostId: postId})
+	}
+	return events
+}
+
+// newMentions returns the distinct users mentioned in after but not in before,
+// leaving out the author of the post.
+func newMentions(before []post.Mention, after []post.Mention, authorId string) []string {
+	res := make([]string, 0)
+	for _, mention := range after {
+		if mention.UserId == authorId || containsString(res, mention.UserId) || mentionsUser(before, mention.UserId) {
+			continue
+		}
+		res = append(res, mention.UserId)
+	}
+	return res
+}
+
+func mentionsUser(mentions []post.Mention, userId string) bool {
+	for _, mention := range mentions {
+		if mention.UserId == userId {
+			return true
+		}
+	}
+	return false
+}
@@ -1,40 +1,200 @@
 package storage
 
 import (
+	"bytes"
 	"context"
-	"github.com/RichardKnop/machinery/v1"
-	"github.com/RichardKnop/machinery/v1/tasks"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"mini-twitter/dispatcher"
+	"mini-twitter/domain/blocks"
 	"mini-twitter/domain/feed"
+	"mini-twitter/domain/filter"
+	"mini-twitter/domain/followrequest"
+	"mini-twitter/domain/like"
+	"mini-twitter/domain/mutes"
+	"mini-twitter/domain/notification"
 	"mini-twitter/domain/post"
 	"mini-twitter/domain/subscribers"
 	"mini-twitter/domain/subscriptions"
+	"mini-twitter/domain/user"
 	"mini-twitter/utils"
-	"strconv"
-	"strings"
+	"time"
 )
 
 type MongoStorage struct {
-	Posts         *mongo.Collection
-	Feed          *mongo.Collection
-	Subscriptions *mongo.Collection
-	Subscribers   *mongo.Collection
-	Server        *machinery.Server
+	Posts          *mongo.Collection
+	Feed           *mongo.Collection
+	Subscriptions  *mongo.Collection
+	Subscribers    *mongo.Collection
+	Users          *mongo.Collection
+	Likes          *mongo.Collection
+	FollowRequests *mongo.Collection
+	Blocks         *mongo.Collection
+	Mutes          *mongo.Collection
+	Filters        *mongo.Collection
+	Outbox         *mongo.Collection
+	Client         *mongo.Client
+	Relay          *OutboxRelay
+	Pages          *PageTokens
 }
 
-func (m *MongoStorage) GetPostById(ctx context.Context, postId string) (*post.Post, error) {
+// withOutbox runs fn in a transaction and writes the events it returns to the
+// outbox within the same transaction, so an event exists if and only if the
+// change that produced it was committed.
+func (m *MongoStorage) withOutbox(ctx context.Context, fn func(sc mongo.SessionContext) ([]dispatcher.Event, error)) error {
+	session, err := m.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		events, err := fn(sc)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			entry, err := newOutboxEntry(e)
+			if err != nil {
+				return nil, err
+			}
+			_, err = m.Outbox.InsertOne(sc, entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if err == nil && m.Relay != nil {
+		m.Relay.Notify()
+	}
+	return err
+}
+
+func (m *MongoStorage) GetPostById(ctx context.Context, viewerId string, postId string) (*post.Post, error) {
 	var p post.Post
 	err := m.Posts.FindOne(ctx, bson.M{"id": postId}).Decode(&p)
-	return &p, err
+	if err != nil {
+		return &p, err
+	}
+	err = checkVisible(ctx, m, viewerId, &p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (m *MongoStorage) AddPost(ctx context.Context, userId string, p *post.Post) error {
+	err := m.checkUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	p.RepostOf = ""
+	return m.addPost(ctx, userId, p)
 }
 
-func (m *MongoStorage) AddPost(ctx context.Context, userId string, p *post.Post) {
+func (m *MongoStorage) Repost(ctx context.Context, userId string, postId string) (*post.Post, error) {
+	err := m.checkUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	p := &post.Post{RepostOf: postId}
+	err = m.addPost(ctx, userId, p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (m *MongoStorage) checkUser(ctx context.Context, userId string) error {
+	err := m.Users.FindOne(ctx, bson.M{"id": userId}).Err()
+	if err == mongo.ErrNoDocuments {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// original returns the post that a repost or a quote of postId refers to.
+// Reposts of reposts are resolved to the post that was reposted first.
+func (m *MongoStorage) original(ctx context.Context, postId string) (*post.Post, error) {
+	var p post.Post
+	err := m.Posts.FindOne(ctx, bson.M{"id": postId}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.RepostOf != "" {
+		return m.original(ctx, p.RepostOf)
+	}
+	return &p, nil
+}
+
+func (m *MongoStorage) addPost(ctx context.Context, userId string, p *post.Post) error {
+	var err error
 	p.CreatedAt = utils.GetCurrentTimestamp()
 	p.LastModifiedAt = utils.GetCurrentTimestamp()
 	p.AuthorId = userId
+	p.InReplyToAuthorId = ""
+	p.ConversationId = ""
+	p.ReplyCount = 0
+	p.RepostCount = 0
+	p.QuoteCount = 0
+	p.Original = nil
+	p.Tags = utils.ExtractTags(p.Text)
+	p.Mentions, err = m.resolveMentions(ctx, p.Text)
+	if err != nil {
+		return err
+	}
+	if p.Visibility == "" {
+		p.Visibility = post.VisibilityPublic
+	}
+	if p.InReplyTo != "" {
+		var parent post.Post
+		err = m.Posts.FindOne(ctx, bson.M{"id": p.InReplyTo}).Decode(&parent)
+		if err == nil {
+			err = checkVisible(ctx, m, userId, &parent)
+		}
+		if err == mongo.ErrNoDocuments || err == ErrPostNotFound {
+			return ErrParentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		p.InReplyToAuthorId = parent.AuthorId
+		p.ConversationId = parent.ConversationId
+		if p.ConversationId == "" {
+			p.ConversationId = parent.Id
+		}
+	}
+	counter := ""
+	if p.RepostOf != "" || p.QuoteOf != "" {
+		counter = "quoteCount"
+		originalId := p.QuoteOf
+		if p.RepostOf != "" {
+			counter = "repostCount"
+			originalId = p.RepostOf
+		}
+		p.Original, err = m.original(ctx, originalId)
+		if err != nil {
+			return err
+		}
+		err = checkShareable(ctx, m, userId, p.Original)
+		if err != nil {
+			return err
+		}
+		if p.RepostOf != "" {
+			p.RepostOf = p.Original.Id
+			err = m.Posts.FindOne(ctx, bson.M{"authorId": userId, "repostOf": p.RepostOf}).Err()
+			if err == nil {
+				return ErrAlreadyReposted
+			}
+		} else {
+			p.QuoteOf = p.Original.Id
+		}
+	}
 	for true {
 		p.Id = utils.GeneratePostId()
 		sr := m.Posts.FindOne(ctx, bson.M{"id": p.Id})
@@ -42,132 +202,270 @@ func (m *MongoStorage) AddPost(ctx context.Context, userId string, p *post.Post)
 			break
 		}
 	}
-	insertRes, _ := m.Posts.InsertOne(ctx, *p)
-
-	signature := &tasks.Signature{
-		Name: "create",
-		Args: []tasks.Arg{
-			{
-				Type:  "string",
-				Value: p.Id,
-			},
-			{
-				Type:  "string",
-				Value: p.AuthorId,
-			},
-			{
-				Type:  "string",
-				Value: p.Text,
-			},
-			{
-				Type:  "string",
-				Value: p.CreatedAt,
-			},
-			{
-				Type:  "string",
-				Value: p.LastModifiedAt,
-			},
-			{
-				Type:  "string",
-				Value: insertRes.InsertedID.(primitive.ObjectID).Hex(),
-			},
-		},
+	if p.ConversationId == "" {
+		p.ConversationId = p.Id
+	}
+	err = m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		// The parent and the original may have been deleted since they were
+		// read above. Updating their counters also makes this transaction
+		// conflict with a concurrent delete.
+		if p.InReplyTo != "" {
+			updateRes, err := m.Posts.UpdateOne(sc, bson.M{"id": p.InReplyTo}, bson.D{{"$inc", bson.D{{"replyCount", 1}}}})
+			if err != nil {
+				return nil, err
+			}
+			if updateRes.MatchedCount == 0 {
+				return nil, ErrParentNotFound
+			}
+		}
+		if counter != "" {
+			updateRes, err := m.Posts.UpdateOne(sc, bson.M{"id": p.Original.Id}, bson.D{{"$inc", bson.D{{counter, 1}}}})
+			if err != nil {
+				return nil, err
+			}
+			if updateRes.MatchedCount == 0 {
+				return nil, ErrPostNotFound
+			}
+		}
+		insertRes, err := m.Posts.InsertOne(sc, *p)
+		if err != nil {
+			return nil, err
+		}
+		events := []dispatcher.Event{dispatcher.PostCreated{
+			Post: *p,
+			Oid:  insertRes.InsertedID.(primitive.ObjectID).Hex(),
+		}}
+		if p.InReplyToAuthorId != "" && p.InReplyToAuthorId != userId {
+			events = append(events, dispatcher.Notified{
+				UserId:  p.InReplyToAuthorId,
+				Type:    notification.TypeReply,
+				ActorId: userId,
+				PostId:  p.Id,
+			})
+		}
+		return m.withoutBlockedRecipients(sc, userId, append(events, mentionEvents(nil, p.Mentions, p.Id, userId)...))
+	})
+	if mongo.IsDuplicateKeyError(err) && p.RepostOf != "" {
+		return ErrAlreadyReposted
 	}
-	_, _ = m.Server.SendTask(signature)
+	return err
 }
 
-func (m *MongoStorage) GetPostsByUserId(ctx context.Context, userId string, token string, size int) ([]*post.Post, string, error) {
-	arr := make([]*post.Post, 0)
-	var filter = bson.M{"authorId": userId}
-	if token != "" {
-		SizeAndPostId := strings.SplitN(token, "-", 2)
-		if len(SizeAndPostId) != 2 {
-			return arr, "", ErrParseToken
+// resolveMentions keeps the mentions of existing users and fills in both the
+// id and the handle of each of them.
+func (m *MongoStorage) resolveMentions(ctx context.Context, text string) ([]post.Mention, error) {
+	mentions := utils.ExtractMentions(text)
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	handles := make([]string, 0, len(mentions))
+	ids := make([]string, 0, len(mentions))
+	for _, mention := range mentions {
+		handles = append(handles, mention.Handle)
+		ids = append(ids, mention.UserId)
+	}
+	filter := bson.M{"$or": bson.A{bson.M{"handle": bson.M{"$in": handles}}, bson.M{"id": bson.M{"$in": ids}}}}
+	cur, err := m.Users.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]user.User, 0)
+	err = cur.All(ctx, &users)
+	if err != nil {
+		return nil, err
+	}
+	byHandle := make(map[string]*user.User, len(users))
+	byId := make(map[string]*user.User, len(users))
+	for i := range users {
+		byHandle[users[i].Handle] = &users[i]
+		byId[users[i].Id] = &users[i]
+	}
+	res := make([]post.Mention, 0, len(mentions))
+	for _, mention := range mentions {
+		u, ok := byId[mention.UserId]
+		if !ok {
+			u, ok = byHandle[mention.Handle]
 		}
-		if size == DEFAULT {
-			size, _ = strconv.Atoi(SizeAndPostId[0])
+		if !ok {
+			continue
 		}
-		postId := SizeAndPostId[1]
-		oid, _ := primitive.ObjectIDFromHex(postId)
-		var p post.Post
-		err := m.Posts.FindOne(ctx, bson.M{"_id": oid}).Decode(&p)
-		if err != nil || p.AuthorId != userId {
-			return arr, "", ErrParseToken
+		mention.UserId = u.Id
+		mention.Handle = u.Handle
+		res = append(res, mention)
+	}
+	return res, nil
+}
+
+func (m *MongoStorage) GetPostsByUserId(ctx context.Context, viewerId string, userId string, before string, since string, size int) (*Page, error) {
+	filter := bson.M{"authorId": userId}
+	var hide func(p *post.Post) (bool, error)
+	if viewerId != userId {
+		r, err := relation(ctx, m, viewerId, userId)
+		if err != nil {
+			return nil, err
 		}
-		filter = bson.M{"$and": bson.A{bson.M{"authorId": userId}, bson.D{{"_id", bson.M{"$lt": oid}}}}}
+		if r.blocked {
+			return nil, ErrBlocked
+		}
+		if r.protected && !r.follows {
+			return nil, ErrForbiddenAccess
+		}
+		filter = bson.M{"$and": bson.A{filter, VisibilityFilter(viewerId, r.follows)}}
+		hide = newViewer(ctx, m, viewerId).hidesOriginal
+	}
+	return m.postsPage(ctx, postsOwner(userId), filter, before, since, size, hide)
+}
+
+func (m *MongoStorage) GetPostsByTag(ctx context.Context, viewerId string, tag string, token string, size int) ([]*post.Post, string, error) {
+	filter := bson.M{"$and": bson.A{bson.M{"tags": tag}, mayBeVisible(viewerId)}}
+	return olderPage(m.postsPage(ctx, tagOwner(tag), filter, token, "", size, newViewer(ctx, m, viewerId).hides))
+}
+
+func (m *MongoStorage) GetReplies(ctx context.Context, viewerId string, postId string, token string, size int) ([]*post.Post, string, error) {
+	filter := bson.M{"$and": bson.A{bson.M{"inReplyTo": postId}, mayBeVisible(viewerId)}}
+	return olderPage(m.postsPage(ctx, repliesOwner(postId), filter, token, "", size, newViewer(ctx, m, viewerId).hides))
+}
+
+// postsPage returns the page of the posts matching filter, newest first,
+// without the ones hidden by hide if it is set.
+func (m *MongoStorage) postsPage(ctx context.Context, owner string, filter bson.M, before string, since string, size int, hide func(p *post.Post) (bool, error)) (*Page, error) {
+	c, err := m.Pages.decodeCursor(owner, before, since, size)
+	if err != nil {
+		return nil, err
+	}
+	entries, hasMore, last, err := filteredEntries(c, hide, func(key string, size int) ([]feed.Entry, error) {
+		return m.postsAfter(ctx, filter, key, c.forward, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Pages.entriesPage(c, entries, hasMore, last), nil
+}
+
+// postsAfter returns up to size posts matching filter that are older than key,
+// newest first, or newer than key, oldest first, if forward is set.
+func (m *MongoStorage) postsAfter(ctx context.Context, filter bson.M, key string, forward bool, size int) ([]feed.Entry, error) {
+	oid, err := keyOid(key)
+	if err != nil {
+		return nil, err
+	}
+	op, order := "$lt", -1
+	if forward {
+		op, order = "$gt", 1
+	}
+	if !oid.IsZero() {
+		filter = bson.M{"$and": bson.A{filter, bson.D{{"_id", bson.M{op: oid}}}}}
 	}
 	opt := options.Find()
-	opt.SetSort(bson.D{{"_id", -1}})
-	cur, _ := m.Posts.Find(ctx, filter, opt)
-	var ok = true
-	if size == DEFAULT {
-		size = 10
+	opt.SetSort(bson.D{{"_id", order}})
+	opt.SetLimit(int64(size))
+	cur, err := m.Posts.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
 	}
-	tokenStart := strconv.Itoa(size) + "-"
-	retToken := ""
-	for size > 0 {
-		ok = cur.Next(ctx)
-		if !ok {
-			retToken = ""
-			break
-		}
-		var pwo post.PostWithOID
-		_ = cur.Decode(&pwo)
-		retToken = tokenStart + pwo.ID.Hex()
+	found := make([]post.PostWithOID, 0)
+	err = cur.All(ctx, &found)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]feed.Entry, 0, len(found))
+	for _, pwo := range found {
 		p := pwo.ToPost()
-		arr = append(arr, &p)
-		size--
+		entries = append(entries, feed.Entry{Oid: pwo.ID.Hex(), Post: &p})
 	}
-	if retToken != "" {
-		if !cur.Next(ctx) {
-			retToken = ""
+	return entries, nil
+}
+
+func (m *MongoStorage) GetThread(ctx context.Context, viewerId string, postId string) ([]*post.Post, error) {
+	thread := make([]*post.Post, 0)
+	v := newViewer(ctx, m, viewerId)
+	for postId != "" && len(thread) <= MaxThreadDepth {
+		var p post.Post
+		err := m.Posts.FindOne(ctx, bson.M{"id": postId}).Decode(&p)
+		if err == mongo.ErrNoDocuments {
+			// Ancestors above a deleted post are unreachable.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hidden, err := v.hides(&p)
+		if err != nil {
+			return nil, err
 		}
+		if hidden {
+			// So are the ones above a post hidden from the viewer.
+			break
+		}
+		thread = append(thread, &p)
+		postId = p.InReplyTo
 	}
-	return arr, retToken, nil
+	if len(thread) == 0 {
+		return nil, ErrPostNotFound
+	}
+	reversePosts(thread)
+	return thread, nil
+}
+
+func (m *MongoStorage) GetConversationMembers(ctx context.Context, viewerId string, conversationId string) ([]string, error) {
+	_, err := m.GetPostById(ctx, viewerId, conversationId)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPostNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	authorIds, err := m.Posts.Distinct(ctx, "authorId", bson.M{"conversationId": conversationId})
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(authorIds))
+	for _, authorId := range authorIds {
+		members = append(members, authorId.(string))
+	}
+	return members, nil
 }
 
 func (m *MongoStorage) ModifyPost(ctx context.Context, userId string, postId string, newPost *post.Post) (*post.Post, error) {
-	filter := bson.D{{"id", postId}, {"authorId", userId}}
-	update := bson.D{{"$set", bson.D{{"text", newPost.Text}, {"lastModifiedAt", utils.GetCurrentTimestamp()}}}}
+	mentions, err := m.resolveMentions(ctx, newPost.Text)
+	if err != nil {
+		return nil, err
+	}
+	// Reposts have no text of their own and cannot be edited.
+	filter := bson.D{{"id", postId}, {"authorId", userId}, {"repostOf", bson.M{"$exists": false}}}
+	update := bson.D{{"$set", bson.D{
+		{"text", newPost.Text},
+		{"tags", utils.ExtractTags(newPost.Text)},
+		{"mentions", mentions},
+		{"lastModifiedAt", utils.GetCurrentTimestamp()},
+	}}}
 	var updatedPost post.PostWithOID
 	opt := options.FindOneAndUpdate()
 	after := options.After
 	opt.ReturnDocument = &after
-	err := m.Posts.FindOneAndUpdate(ctx, filter, update, opt).Decode(&updatedPost)
+	err = m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		var before post.Post
+		err := m.Posts.FindOne(sc, filter).Decode(&before)
+		if err != nil {
+			return nil, err
+		}
+		err = m.Posts.FindOneAndUpdate(sc, filter, update, opt).Decode(&updatedPost)
+		if err != nil {
+			return nil, err
+		}
+		events := []dispatcher.Event{dispatcher.PostModified{
+			Post: updatedPost.ToPost(),
+			Oid:  updatedPost.ID.Hex(),
+		}}
+		return m.withoutBlockedRecipients(sc, userId, append(events, mentionEvents(before.Mentions, mentions, postId, userId)...))
+	})
 	if err == nil {
-		signature := &tasks.Signature{
-			Name: "modify",
-			Args: []tasks.Arg{
-				{
-					Type:  "string",
-					Value: updatedPost.Id,
-				},
-				{
-					Type:  "string",
-					Value: updatedPost.AuthorId,
-				},
-				{
-					Type:  "string",
-					Value: updatedPost.Text,
-				},
-				{
-					Type:  "string",
-					Value: updatedPost.CreatedAt,
-				},
-				{
-					Type:  "string",
-					Value: updatedPost.LastModifiedAt,
-				},
-				{
-					Type:  "string",
-					Value: updatedPost.ID.Hex(),
-				},
-			},
-		}
-		_, _ = m.Server.SendTask(signature)
 		updatedPostWithoutOID := updatedPost.ToPost()
 		return &updatedPostWithoutOID, nil
 	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
 	filterWithoutAuthorId := bson.D{{"id", postId}}
 	err = m.Posts.FindOne(ctx, filterWithoutAuthorId).Err()
 	if err != nil {
@@ -176,62 +474,192 @@ func (m *MongoStorage) ModifyPost(ctx context.Context, userId string, postId str
 	return nil, ErrForbiddenAccess
 }
 
+func (m *MongoStorage) DeletePost(ctx context.Context, userId string, postId string) error {
+	filter := bson.D{{"id", postId}, {"authorId", userId}}
+	err := m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		var deletedPost post.PostWithOID
+		err := m.Posts.FindOneAndDelete(sc, filter).Decode(&deletedPost)
+		if err != nil {
+			return nil, err
+		}
+		counters := []struct{ postId, counter string }{
+			{deletedPost.InReplyTo, "replyCount"},
+			{deletedPost.RepostOf, "repostCount"},
+			{deletedPost.QuoteOf, "quoteCount"},
+		}
+		for _, c := range counters {
+			if c.postId == "" {
+				continue
+			}
+			_, err = m.Posts.UpdateOne(sc, bson.M{"id": c.postId}, bson.D{{"$inc", bson.D{{c.counter, -1}}}})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return []dispatcher.Event{dispatcher.PostDeleted{
+			PostId:   deletedPost.Id,
+			AuthorId: deletedPost.AuthorId,
+			Oid:      deletedPost.ID.Hex(),
+		}}, nil
+	})
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+	filterWithoutAuthorId := bson.D{{"id", postId}}
+	err = m.Posts.FindOne(ctx, filterWithoutAuthorId).Err()
+	if err != nil {
+		return ErrPostNotFound
+	}
+	return ErrForbiddenAccess
+}
+
 func (m *MongoStorage) Subscribe(ctx context.Context, subscribee string, subscriber string) error {
 	if subscribee == subscriber {
 		return ErrInvalidSubscribe
 	}
+	requested := false
+	err := m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		// The transaction may be retried.
+		requested = false
+		cur, err := m.Users.Find(sc, bson.M{"id": bson.M{"$in": bson.A{subscribee, subscriber}}})
+		if err != nil {
+			return nil, err
+		}
+		users := make([]user.User, 0)
+		err = cur.All(sc, &users)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) != 2 {
+			return nil, ErrUserNotFound
+		}
+		blocked, err := m.blockedBetween(sc, subscribee, subscriber)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return nil, ErrBlocked
+		}
+		protected := users[0].Protected
+		if users[0].Id != subscribee {
+			protected = users[1].Protected
+		}
+		if !protected {
+			return m.subscribe(sc, subscribee, subscriber)
+		}
+		err = m.Subscribers.FindOne(sc, bson.D{{"user", subscribee}, {"subscribers", subscriber}}).Err()
+		if err == nil {
+			return nil, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		requested = true
+		filter := bson.D{{"subscribee", subscribee}, {"subscriber", subscriber}}
+		update := bson.D{{"$setOnInsert", bson.D{{"createdAt", utils.GetCurrentTimestamp()}}}}
+		updateRes, err := m.FollowRequests.UpdateOne(sc, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, err
+		}
+		if updateRes.UpsertedCount == 0 {
+			return nil, nil
+		}
+		return []dispatcher.Event{
+			dispatcher.Notified{UserId: subscribee, Type: notification.TypeFollowRequest, ActorId: subscriber},
+		}, nil
+	})
+	if err == nil && requested {
+		return ErrFollowRequested
+	}
+	return err
+}
+
+// subscribe adds the subscription within the transaction of sc and returns its
+// events.
+func (m *MongoStorage) subscribe(sc mongo.SessionContext, subscribee string, subscriber string) ([]dispatcher.Event, error) {
 	flag := true
-	err := m.Subscribers.FindOne(ctx, bson.D{
+	err := m.Subscribers.FindOne(sc, bson.D{
 		{"user", subscribee},
 	}).Err()
 	if err != nil {
 		newS := subscribers.Subscribers{UserId: subscribee, Subscribers: make([]string, 0)}
 		newS.Subscribers = append(newS.Subscribers, subscriber)
-		_, _ = m.Subscribers.InsertOne(ctx, newS)
+		_, err = m.Subscribers.InsertOne(sc, newS)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		filter := bson.D{{"user", subscribee}, {"subscribers", bson.M{"$not": bson.M{"$eq": subscriber}}}}
 		update := bson.D{{"$push", bson.M{"subscribers": subscriber}}}
-		updateRes, err2 := m.Subscribers.UpdateOne(ctx, filter, update)
-		if err2 != nil {
-			return err
+		updateRes, err := m.Subscribers.UpdateOne(sc, filter, update)
+		if err != nil {
+			return nil, err
 		}
 		if updateRes.MatchedCount == 0 {
 			flag = false
 		}
 	}
 
-	err = m.Subscriptions.FindOne(ctx, bson.D{
+	err = m.Subscriptions.FindOne(sc, bson.D{
 		{"user", subscriber},
 	}).Err()
 	if err != nil {
 		newS := subscriptions.Subscriptions{UserId: subscriber, Subscriptions: make([]string, 0)}
 		newS.Subscriptions = append(newS.Subscriptions, subscribee)
-		_, _ = m.Subscriptions.InsertOne(ctx, newS)
+		_, err = m.Subscriptions.InsertOne(sc, newS)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		filter := bson.D{{"user", subscriber}, {"subscriptions", bson.M{"$not": bson.M{"$eq": subscribee}}}}
 		update := bson.D{{"$push", bson.M{"subscriptions": subscribee}}}
-		_, err = m.Subscriptions.UpdateOne(ctx, filter, update)
+		_, err = m.Subscriptions.UpdateOne(sc, filter, update)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	signature := &tasks.Signature{
-		Name: "subscribe",
-		Args: []tasks.Arg{
-			{
-				Type:  "string",
-				Value: subscribee,
-			},
-			{
-				Type:  "string",
-				Value: subscriber,
-			},
-		},
+	if !flag {
+		return nil, nil
 	}
-	if flag {
-		_, _ = m.Server.SendTask(signature)
+	return []dispatcher.Event{
+		dispatcher.Subscribed{Subscribee: subscribee, Subscriber: subscriber},
+		dispatcher.Notified{UserId: subscribee, Type: notification.TypeFollow, ActorId: subscriber},
+	}, nil
+}
+
+func (m *MongoStorage) Unsubscribe(ctx context.Context, subscribee string, subscriber string) error {
+	if subscribee == subscriber {
+		return ErrInvalidSubscribe
 	}
-	return nil
+	return m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		return m.unsubscribe(sc, subscribee, subscriber)
+	})
+}
+
+// unsubscribe removes the subscription within the transaction of sc and
+// returns its events. It also cancels a pending follow request.
+func (m *MongoStorage) unsubscribe(sc mongo.SessionContext, subscribee string, subscriber string) ([]dispatcher.Event, error) {
+	_, err := m.FollowRequests.DeleteOne(sc, bson.D{{"subscribee", subscribee}, {"subscriber", subscriber}})
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.D{{"user", subscribee}, {"subscribers", subscriber}}
+	update := bson.D{{"$pull", bson.M{"subscribers": subscriber}}}
+	updateRes, err := m.Subscribers.UpdateOne(sc, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if updateRes.ModifiedCount == 0 {
+		return nil, nil
+	}
+
+	filter = bson.D{{"user", subscriber}}
+	update = bson.D{{"$pull", bson.M{"subscriptions": subscribee}}}
+	_, err = m.Subscriptions.UpdateOne(sc, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	return []dispatcher.Event{dispatcher.Unsubscribed{Subscribee: subscribee, Subscriber: subscriber}}, nil
 }
 
 func (m *MongoStorage) GetSubscribers(ctx context.Context, userId string) ([]string, error) {
@@ -254,52 +682,824 @@ func (m *MongoStorage) GetSubscriptions(ctx context.Context, userId string) ([]s
 	return s.Subscriptions, err
 }
 
-func (m *MongoStorage) GetFeed(ctx context.Context, userId string, token string, size int) ([]*post.Post, string, error) {
-	arr := make([]*post.Post, 0)
-	var filter = bson.M{"userId": userId}
-	if token != "" {
-		SizeAndFeedId := strings.SplitN(token, "-", 2)
-		if len(SizeAndFeedId) != 2 {
-			return arr, "", ErrParseToken
+func (m *MongoStorage) Block(ctx context.Context, userId string, blockedId string) error {
+	if userId == blockedId {
+		return ErrInvalidBlock
+	}
+	return m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		err := m.checkUser(sc, blockedId)
+		if err != nil {
+			return nil, err
+		}
+		filter := bson.D{{"user", userId}}
+		update := bson.D{{"$addToSet", bson.M{"blocked": blockedId}}}
+		_, err = m.Blocks.UpdateOne(sc, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, err
 		}
-		if size == DEFAULT {
-			size, _ = strconv.Atoi(SizeAndFeedId[0])
+		events, err := m.unsubscribe(sc, userId, blockedId)
+		if err != nil {
+			return nil, err
 		}
-		feedId := SizeAndFeedId[1]
-		oid, _ := primitive.ObjectIDFromHex(feedId)
-		var f feed.Feed
-		err := m.Feed.FindOne(ctx, bson.M{"oid": oid}).Decode(&f)
+		reverse, err := m.unsubscribe(sc, blockedId, userId)
 		if err != nil {
-			return arr, "", ErrParseToken
+			return nil, err
 		}
-		filter = bson.M{"$and": bson.A{bson.M{"userId": userId}, bson.D{{"oid", bson.M{"$lt": oid}}}}}
+		return append(events, reverse...), nil
+	})
+}
+
+func (m *MongoStorage) Unblock(ctx context.Context, userId string, blockedId string) error {
+	filter := bson.D{{"user", userId}}
+	update := bson.D{{"$pull", bson.M{"blocked": blockedId}}}
+	_, err := m.Blocks.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (m *MongoStorage) GetBlocked(ctx context.Context, userId string) ([]string, error) {
+	var b blocks.Blocks
+	err := m.Blocks.FindOne(ctx, bson.D{{"user", userId}}).Decode(&b)
+	if err == mongo.ErrNoDocuments {
+		return []string{}, nil
+	}
+	return b.Blocked, err
+}
+
+func (m *MongoStorage) GetBlockedWith(ctx context.Context, userId string) ([]string, error) {
+	blocked, err := m.GetBlocked(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	blockers, err := m.Blocks.Distinct(ctx, "user", bson.M{"blocked": userId})
+	if err != nil {
+		return nil, err
+	}
+	for _, blocker := range blockers {
+		blocked = append(blocked, blocker.(string))
+	}
+	return blocked, nil
+}
+
+// blockedBetween reports whether either of the users blocked the other.
+func (m *MongoStorage) blockedBetween(ctx context.Context, userId string, otherId string) (bool, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"user": userId, "blocked": otherId},
+		bson.M{"user": otherId, "blocked": userId},
+	}}
+	count, err := m.Blocks.CountDocuments(ctx, filter)
+	return count != 0, err
+}
+
+// withoutBlockedRecipients drops the notifications addressed to users who
+// blocked actorId or whom actorId blocked.
+func (m *MongoStorage) withoutBlockedRecipients(ctx context.Context, actorId string, events []dispatcher.Event) ([]dispatcher.Event, error) {
+	recipients := make([]string, 0)
+	for _, event := range events {
+		switch e := event.(type) {
+		case dispatcher.Notified:
+			recipients = append(recipients, e.UserId)
+		}
+	}
+	if len(recipients) == 0 {
+		return events, nil
+	}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"user": actorId},
+		bson.M{"user": bson.M{"$in": recipients}, "blocked": actorId},
+	}}
+	cur, err := m.Blocks.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var lists []blocks.Blocks
+	err = cur.All(ctx, &lists)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[string]bool)
+	for _, b := range lists {
+		if b.UserId != actorId {
+			blocked[b.UserId] = true
+			continue
+		}
+		for _, userId := range b.Blocked {
+			blocked[userId] = true
+		}
+	}
+	res := make([]dispatcher.Event, 0, len(events))
+	for _, event := range events {
+		if e, ok := event.(dispatcher.Notified); ok && blocked[e.UserId] {
+			continue
+		}
+		res = append(res, event)
+	}
+	return res, nil
+}
+
+func (m *MongoStorage) Mute(ctx context.Context, userId string, mutedId string) error {
+	if userId == mutedId {
+		return ErrInvalidBlock
+	}
+	err := m.checkUser(ctx, mutedId)
+	if err != nil {
+		return err
+	}
+	filter := bson.D{{"user", userId}}
+	update := bson.D{{"$addToSet", bson.M{"muted": mutedId}}}
+	_, err = m.Mutes.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func (m *MongoStorage) Unmute(ctx context.Context, userId string, mutedId string) error {
+	filter := bson.D{{"user", userId}}
+	update := bson.D{{"$pull", bson.M{"muted": mutedId}}}
+	_, err := m.Mutes.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (m *MongoStorage) GetMuted(ctx context.Context, userId string) ([]string, error) {
+	var mt mutes.Mutes
+	err := m.Mutes.FindOne(ctx, bson.D{{"user", userId}}).Decode(&mt)
+	if err == mongo.ErrNoDocuments {
+		return []string{}, nil
+	}
+	return mt.Muted, err
+}
+
+func (m *MongoStorage) AddFilter(ctx context.Context, userId string, f *filter.Filter) error {
+	count, err := m.Filters.CountDocuments(ctx, activeFilters(userId))
+	if err != nil {
+		return err
 	}
+	if count >= MaxFilters {
+		return ErrTooManyFilters
+	}
+	f.ID = primitive.NewObjectID()
+	f.UserId = userId
+	f.CreatedAt = utils.GetCurrentTimestamp()
+	_, err = m.Filters.InsertOne(ctx, f)
+	return err
+}
+
+func (m *MongoStorage) GetFilters(ctx context.Context, userId string) ([]*filter.Filter, error) {
+	filters := make([]*filter.Filter, 0)
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", 1}})
+	cur, err := m.Filters.Find(ctx, activeFilters(userId), opt)
+	if err != nil {
+		return filters, err
+	}
+	err = cur.All(ctx, &filters)
+	return filters, err
+}
+
+// activeFilters selects the filters of the user that have not expired.
+func activeFilters(userId string) bson.M {
+	return bson.M{
+		"userId": userId,
+		"$or": bson.A{
+			bson.M{"expiresAt": bson.M{"$exists": false}},
+			bson.M{"expiresAt": bson.M{"$gt": time.Now()}},
+		},
+	}
+}
+
+func (m *MongoStorage) DeleteFilter(ctx context.Context, userId string, filterId string) error {
+	oid, err := primitive.ObjectIDFromHex(filterId)
+	if err != nil {
+		return ErrFilterNotFound
+	}
+	res, err := m.Filters.DeleteOne(ctx, bson.M{"_id": oid, "userId": userId})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrFilterNotFound
+	}
+	return nil
+}
+
+func (m *MongoStorage) GetFollowRequests(ctx context.Context, userId string) ([]string, error) {
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", 1}})
+	cur, err := m.FollowRequests.Find(ctx, bson.D{{"subscribee", userId}}, opt)
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]followrequest.FollowRequest, 0)
+	err = cur.All(ctx, &requests)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(requests))
+	for _, r := range requests {
+		res = append(res, r.Subscriber)
+	}
+	return res, nil
+}
+
+func (m *MongoStorage) ApproveFollowRequest(ctx context.Context, userId string, subscriber string) error {
+	return m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		deleteRes, err := m.FollowRequests.DeleteOne(sc, bson.D{{"subscribee", userId}, {"subscriber", subscriber}})
+		if err != nil {
+			return nil, err
+		}
+		if deleteRes.DeletedCount == 0 {
+			return nil, ErrFollowRequestNotFound
+		}
+		return m.subscribe(sc, userId, subscriber)
+	})
+}
+
+// approveFollowRequests turns all follow requests to the user into
+// subscriptions.
+func (m *MongoStorage) approveFollowRequests(sc mongo.SessionContext, userId string) ([]dispatcher.Event, error) {
+	cur, err := m.FollowRequests.Find(sc, bson.D{{"subscribee", userId}})
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]followrequest.FollowRequest, 0)
+	err = cur.All(sc, &requests)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.FollowRequests.DeleteMany(sc, bson.D{{"subscribee", userId}})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]dispatcher.Event, 0)
+	for _, request := range requests {
+		subscribed, err := m.subscribe(sc, userId, request.Subscriber)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, subscribed...)
+	}
+	return events, nil
+}
+
+func (m *MongoStorage) RejectFollowRequest(ctx context.Context, userId string, subscriber string) error {
+	deleteRes, err := m.FollowRequests.DeleteOne(ctx, bson.D{{"subscribee", userId}, {"subscriber", subscriber}})
+	if err != nil {
+		return err
+	}
+	if deleteRes.DeletedCount == 0 {
+		return ErrFollowRequestNotFound
+	}
+	return nil
+}
+
+// GetFeed merges two sources ordered by post ObjectID: the feed rows written
+// by the worker and the posts of followed authors that have too many followers
+// to be fanned out on write and are marked with onRead instead.
+func (m *MongoStorage) GetFeed(ctx context.Context, userId string, before string, since string, size int) (*Page, error) {
+	c, err := m.Pages.decodeCursor(feedOwner(userId), before, since, size)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := m.GetFilters(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	ff := utils.NewFeedFilter(filters)
+	hide := func(p *post.Post) (bool, error) {
+		return ff.Hides(p), nil
+	}
+	entries, hasMore, last, err := filteredEntries(c, hide, func(key string, size int) ([]feed.Entry, error) {
+		if c.forward {
+			return m.GetFeedSince(ctx, userId, key, size)
+		}
+		oid, err := keyOid(key)
+		if err != nil {
+			return nil, err
+		}
+		return m.feedBefore(ctx, userId, oid, size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m.Pages.entriesPage(c, entries, hasMore, last), nil
+}
+
+// feedBefore returns up to size entries of the feed that are older than
+// before, newest first. A zero before starts from the newest entry.
+func (m *MongoStorage) feedBefore(ctx context.Context, userId string, before primitive.ObjectID, size int) ([]feed.Entry, error) {
+	entries := make([]feed.Entry, 0)
+	var feedFilter = bson.M{"userId": userId}
+	var postsFilter = bson.M{"onRead": true}
+	if !before.IsZero() {
+		feedFilter["oid"] = bson.M{"$lt": before}
+		postsFilter["_id"] = bson.M{"$lt": before}
+	}
+	muted, err := m.GetMuted(ctx, userId)
+	if err != nil {
+		return entries, err
+	}
+	blocked, err := m.GetBlockedWith(ctx, userId)
+	if err != nil {
+		return entries, err
+	}
+	addHiddenFilter(feedFilter, muted, blocked)
+
 	opt := options.Find()
 	opt.SetSort(bson.D{{"oid", -1}})
-	cur, _ := m.Feed.Find(ctx, filter, opt)
-	var ok = true
+	opt.SetLimit(int64(size))
+	cur, err := m.Feed.Find(ctx, feedFilter, opt)
+	if err != nil {
+		return entries, err
+	}
+	materialized := make([]feed.Feed, 0)
+	err = cur.All(ctx, &materialized)
+	if err != nil {
+		return entries, err
+	}
+
+	live := make([]post.PostWithOID, 0)
+	subscriptions, err := m.GetSubscriptions(ctx, userId)
+	if err != nil {
+		return entries, err
+	}
+	if len(subscriptions) != 0 {
+		addLiveFilter(postsFilter, userId, subscriptions, muted, blocked)
+		opt = options.Find()
+		opt.SetSort(bson.D{{"_id", -1}})
+		opt.SetLimit(int64(size))
+		cur, err = m.Posts.Find(ctx, postsFilter, opt)
+		if err != nil {
+			return entries, err
+		}
+		err = cur.All(ctx, &live)
+		if err != nil {
+			return entries, err
+		}
+	}
+
+	// Both slices are sorted by ObjectID descending. A post can be in both if
+	// its author crossed the threshold after the post was fanned out.
+	seen := make(map[primitive.ObjectID]bool)
+	arr := make([]*post.Post, 0)
+	i, j := 0, 0
+	for (i < len(materialized) || j < len(live)) && len(arr) < size {
+		var entryOid primitive.ObjectID
+		var p post.Post
+		if j == len(live) || (i < len(materialized) && bytes.Compare(materialized[i].Oid[:], live[j].ID[:]) > 0) {
+			entryOid, p = materialized[i].Oid, materialized[i].ToPost()
+			i++
+		} else {
+			entryOid, p = live[j].ID, live[j].ToPost()
+			j++
+		}
+		if seen[entryOid] {
+			continue
+		}
+		seen[entryOid] = true
+		arr = append(arr, &p)
+		entries = append(entries, feed.Entry{Oid: entryOid.Hex(), Post: &p})
+	}
+	return entries, m.hydrateCounters(ctx, arr)
+}
+
+// addHiddenFilter leaves out the posts of muted authors, and the reposts and
+// quotes of their posts and of the posts of the authors the user has a block
+// with.
+func addHiddenFilter(filter bson.M, muted []string, blocked []string) {
+	if len(muted) != 0 {
+		filter["authorId"] = bson.M{"$nin": muted}
+	}
+	if len(muted)+len(blocked) != 0 {
+		filter["original.authorId"] = bson.M{"$nin": append(append([]string{}, muted...), blocked...)}
+	}
+}
+
+// addLiveFilter restricts the posts that are read live to the ones the user
+// should see in the feed.
+func addLiveFilter(filter bson.M, userId string, subscriptions []string, muted []string, blocked []string) {
+	addHiddenFilter(filter, muted, blocked)
+	filter["authorId"] = bson.M{"$in": subscriptions, "$nin": muted}
+	// Replies are only shown to users who follow the replied-to author.
+	replies := bson.M{"$or": bson.A{
+		bson.M{"inReplyToAuthorId": bson.M{"$exists": false}},
+		bson.M{"inReplyToAuthorId": bson.M{"$in": append(subscriptions, userId)}},
+		bson.M{"$expr": bson.M{"$eq": bson.A{"$inReplyToAuthorId", "$authorId"}}},
+	}}
+	filter["$and"] = bson.A{replies, VisibilityFilter(userId, true)}
+}
+
+// GetFeedSince merges the feed collection with the live posts like GetFeed,
+// walking forward from oid. The entry at oid does not have to exist anymore.
+func (m *MongoStorage) GetFeedSince(ctx context.Context, userId string, oid string, size int) ([]feed.Entry, error) {
+	entries := make([]feed.Entry, 0)
+	since, err := primitive.ObjectIDFromHex(oid)
+	if err != nil {
+		return entries, ErrParseToken
+	}
+	muted, err := m.GetMuted(ctx, userId)
+	if err != nil {
+		return entries, err
+	}
+	blocked, err := m.GetBlockedWith(ctx, userId)
+	if err != nil {
+		return entries, err
+	}
+	feedFilter := bson.M{"userId": userId, "oid": bson.M{"$gt": since}}
+	addHiddenFilter(feedFilter, muted, blocked)
+	opt := options.Find()
+	opt.SetSort(bson.D{{"oid", 1}})
+	opt.SetLimit(int64(size))
+	cur, err := m.Feed.Find(ctx, feedFilter, opt)
+	if err != nil {
+		return entries, err
+	}
+	materialized := make([]feed.Feed, 0)
+	err = cur.All(ctx, &materialized)
+	if err != nil {
+		return entries, err
+	}
+
+	live := make([]post.PostWithOID, 0)
+	subscriptions, err := m.GetSubscriptions(ctx, userId)
+	if err != nil {
+		return entries, err
+	}
+	if len(subscriptions) != 0 {
+		postsFilter := bson.M{"onRead": true, "_id": bson.M{"$gt": since}}
+		addLiveFilter(postsFilter, userId, subscriptions, muted, blocked)
+		opt = options.Find()
+		opt.SetSort(bson.D{{"_id", 1}})
+		opt.SetLimit(int64(size))
+		cur, err = m.Posts.Find(ctx, postsFilter, opt)
+		if err != nil {
+			return entries, err
+		}
+		err = cur.All(ctx, &live)
+		if err != nil {
+			return entries, err
+		}
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	arr := make([]*post.Post, 0)
+	i, j := 0, 0
+	for (i < len(materialized) || j < len(live)) && len(arr) < size {
+		var entryOid primitive.ObjectID
+		var p post.Post
+		if j == len(live) || (i < len(materialized) && bytes.Compare(materialized[i].Oid[:], live[j].ID[:]) < 0) {
+			entryOid, p = materialized[i].Oid, materialized[i].ToPost()
+			i++
+		} else {
+			entryOid, p = live[j].ID, live[j].ToPost()
+			j++
+		}
+		if seen[entryOid] {
+			continue
+		}
+		seen[entryOid] = true
+		arr = append(arr, &p)
+		entries = append(entries, feed.Entry{Oid: entryOid.Hex(), Post: &p})
+	}
+	return entries, m.hydrateCounters(ctx, arr)
+}
+
+// hydrateCounters copies counters from the posts collection into posts read
+// from the feed collection, where they are not kept up to date.
+func (m *MongoStorage) hydrateCounters(ctx context.Context, arr []*post.Post) error {
+	if len(arr) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(arr))
+	for _, p := range arr {
+		ids = append(ids, p.Id)
+	}
+	opt := options.Find().SetProjection(bson.D{{"id", 1}, {"replyCount", 1}, {"repostCount", 1}, {"quoteCount", 1}, {"likeCount", 1}})
+	cur, err := m.Posts.Find(ctx, bson.M{"id": bson.M{"$in": ids}}, opt)
+	if err != nil {
+		return err
+	}
+	current := make([]post.Post, 0, len(arr))
+	err = cur.All(ctx, &current)
+	if err != nil {
+		return err
+	}
+	byId := make(map[string]*post.Post, len(current))
+	for i := range current {
+		byId[current[i].Id] = &current[i]
+	}
+	for _, p := range arr {
+		if c, ok := byId[p.Id]; ok {
+			p.ReplyCount = c.ReplyCount
+			p.RepostCount = c.RepostCount
+			p.QuoteCount = c.QuoteCount
+			p.LikeCount = c.LikeCount
+		}
+	}
+	return nil
+}
+
+// Like is idempotent: a second like of the same post is ignored. Likes of a
+// repost go to the reposted post.
+func (m *MongoStorage) Like(ctx context.Context, userId string, postId string) error {
+	err := m.checkUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	original, err := m.original(ctx, postId)
+	if err != nil {
+		return err
+	}
+	err = checkVisible(ctx, m, userId, original)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"postId": original.Id, "userId": userId}
+	err = m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		err := m.Likes.FindOne(sc, filter).Err()
+		if err == nil {
+			return nil, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		updateRes, err := m.Posts.UpdateOne(sc, bson.M{"id": original.Id}, bson.D{{"$inc", bson.D{{"likeCount", 1}}}})
+		if err != nil {
+			return nil, err
+		}
+		if updateRes.MatchedCount == 0 {
+			return nil, ErrPostNotFound
+		}
+		_, err = m.Likes.InsertOne(sc, like.Like{UserId: userId, PostId: original.Id, CreatedAt: utils.GetCurrentTimestamp()})
+		if err != nil || original.AuthorId == userId {
+			return nil, err
+		}
+		return m.withoutBlockedRecipients(sc, userId, []dispatcher.Event{dispatcher.Notified{
+			UserId:  original.AuthorId,
+			Type:    notification.TypeLike,
+			ActorId: userId,
+			PostId:  original.Id,
+		}})
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+func (m *MongoStorage) Unlike(ctx context.Context, userId string, postId string) error {
+	original, err := m.original(ctx, postId)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"postId": original.Id, "userId": userId}
+	return m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		deleteRes, err := m.Likes.DeleteOne(sc, filter)
+		if err != nil || deleteRes.DeletedCount == 0 {
+			return nil, err
+		}
+		_, err = m.Posts.UpdateOne(sc, bson.M{"id": original.Id}, bson.D{{"$inc", bson.D{{"likeCount", -1}}}})
+		return nil, err
+	})
+}
+
+func (m *MongoStorage) GetLikes(ctx context.Context, postId string, token string, size int) ([]string, string, error) {
+	userIds := make([]string, 0)
+	// Likes of a repost are stored on the original, see Like.
+	original, err := m.original(ctx, postId)
+	if err != nil {
+		return userIds, "", err
+	}
+	likes, retToken, err := m.likesPage(ctx, likesOwner(original.Id), bson.M{"postId": original.Id}, token, size)
+	if err != nil {
+		return userIds, "", err
+	}
+	for _, l := range likes {
+		userIds = append(userIds, l.UserId)
+	}
+	return userIds, retToken, nil
+}
+
+// GetLikedPosts returns the posts liked by the user, the most recently liked
+// first.
+func (m *MongoStorage) GetLikedPosts(ctx context.Context, viewerId string, userId string, token string, size int) ([]*post.Post, string, error) {
+	arr := make([]*post.Post, 0)
+	if viewerId != userId {
+		r, err := relation(ctx, m, viewerId, userId)
+		if err != nil {
+			return arr, "", err
+		}
+		if r.blocked {
+			return arr, "", ErrBlocked
+		}
+		if r.protected && !r.follows {
+			return arr, "", ErrForbiddenAccess
+		}
+	}
+	likes, retToken, err := m.likesPage(ctx, likedOwner(userId), bson.M{"userId": userId}, token, size)
+	if err != nil || len(likes) == 0 {
+		return arr, retToken, err
+	}
+	ids := make([]string, 0, len(likes))
+	for _, l := range likes {
+		ids = append(ids, l.PostId)
+	}
+	cur, err := m.Posts.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
+	if err != nil {
+		return arr, "", err
+	}
+	posts := make([]post.Post, 0, len(ids))
+	err = cur.All(ctx, &posts)
+	if err != nil {
+		return arr, "", err
+	}
+	byId := make(map[string]*post.Post, len(posts))
+	for i := range posts {
+		byId[posts[i].Id] = &posts[i]
+	}
+	v := newViewer(ctx, m, viewerId)
+	for _, l := range likes {
+		p, ok := byId[l.PostId]
+		if !ok {
+			continue
+		}
+		hidden, err := v.hides(p)
+		if err != nil {
+			return make([]*post.Post, 0), "", err
+		}
+		if !hidden {
+			arr = append(arr, p)
+		}
+	}
+	return arr, retToken, nil
+}
+
+// likesPage returns the likes matching filter, newest first.
+func (m *MongoStorage) likesPage(ctx context.Context, owner string, filter bson.M, token string, size int) ([]like.Like, string, error) {
+	likes := make([]like.Like, 0)
+	oid, size, err := m.Pages.DecodeOid(owner, token, size)
+	if err != nil {
+		return likes, "", err
+	}
+	if !oid.IsZero() {
+		filter = bson.M{"$and": bson.A{filter, bson.D{{"_id", bson.M{"$lt": oid}}}}}
+	}
 	if size == DEFAULT {
 		size = 10
 	}
-	tokenStart := strconv.Itoa(size) + "-"
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", -1}})
+	opt.SetLimit(int64(size) + 1)
+	cur, err := m.Likes.Find(ctx, filter, opt)
+	if err != nil {
+		return likes, "", err
+	}
+	err = cur.All(ctx, &likes)
+	if err != nil {
+		return likes, "", err
+	}
 	retToken := ""
-	for size > 0 {
-		ok = cur.Next(ctx)
-		if !ok {
-			retToken = ""
-			break
+	if len(likes) > size {
+		likes = likes[:size]
+		retToken = m.Pages.Encode(owner, likes[size-1].ID.Hex(), size)
+	}
+	return likes, retToken, nil
+}
+
+func (m *MongoStorage) AddUser(ctx context.Context, u *user.User) error {
+	u.CreatedAt = utils.GetCurrentTimestamp()
+	_, err := m.Users.InsertOne(ctx, u)
+	if mongo.IsDuplicateKeyError(err) {
+		if m.Users.FindOne(ctx, bson.M{"id": u.Id}).Err() == nil {
+			return ErrUserExists
 		}
-		var f feed.Feed
-		_ = cur.Decode(&f)
-		retToken = tokenStart + f.Oid.Hex()
-		p := f.ToPost()
-		arr = append(arr, &p)
-		size--
+		return ErrHandleTaken
+	}
+	return err
+}
+
+func (m *MongoStorage) GetUserById(ctx context.Context, userId string) (*user.User, error) {
+	return m.findUser(ctx, bson.M{"id": userId})
+}
+
+func (m *MongoStorage) GetUserByHandle(ctx context.Context, handle string) (*user.User, error) {
+	return m.findUser(ctx, bson.M{"handle": handle})
+}
+
+func (m *MongoStorage) findUser(ctx context.Context, filter bson.M) (*user.User, error) {
+	var u user.User
+	err := m.Users.FindOne(ctx, filter).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (m *MongoStorage) ModifyUser(ctx context.Context, userId string, patch *user.Patch) (*user.User, error) {
+	set := bson.D{}
+	if patch.Handle != nil {
+		set = append(set, bson.E{"handle", *patch.Handle})
 	}
-	if retToken != "" {
-		if !cur.Next(ctx) {
-			retToken = ""
+	if patch.DisplayName != nil {
+		set = append(set, bson.E{"displayName", *patch.DisplayName})
+	}
+	if patch.Bio != nil {
+		set = append(set, bson.E{"bio", *patch.Bio})
+	}
+	if patch.Protected != nil {
+		set = append(set, bson.E{"protected", *patch.Protected})
+	}
+	if len(set) == 0 {
+		return m.GetUserById(ctx, userId)
+	}
+	var u user.User
+	opt := options.FindOneAndUpdate()
+	after := options.After
+	opt.ReturnDocument = &after
+	err := m.withOutbox(ctx, func(sc mongo.SessionContext) ([]dispatcher.Event, error) {
+		err := m.Users.FindOneAndUpdate(sc, bson.M{"id": userId}, bson.D{{"$set", set}}, opt).Decode(&u)
+		if err != nil || patch.Protected == nil || *patch.Protected {
+			return nil, err
 		}
+		// Nobody has to approve the followers of an open account.
+		return m.approveFollowRequests(sc, userId)
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrHandleTaken
 	}
-	return arr, retToken, nil
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// EnsureIndexes creates the indexes the queries above rely on.
+func (m *MongoStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := m.Users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"id", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"handle", 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range []*mongo.Collection{m.Blocks, m.Mutes} {
+		_, err = c.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{"user", 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = m.Filters.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"userId", 1}, {"_id", 1}}},
+		// MongoDB deletes the expired filters, GetFilters skips the ones it
+		// has not got to yet.
+		{Keys: bson.D{{"expiresAt", 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.FollowRequests.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"subscribee", 1}, {"subscriber", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.Likes.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"postId", 1}, {"userId", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"postId", 1}, {"_id", -1}}},
+		{Keys: bson.D{{"userId", 1}, {"_id", -1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.Feed.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"userId", 1}, {"oid", -1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"repostOf", 1}}},
+		{Keys: bson.D{{"original.id", 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.Posts.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"authorId", 1}, {"_id", -1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"onRead": true}),
+		},
+		{Keys: bson.D{{"inReplyTo", 1}, {"_id", -1}}},
+		{Keys: bson.D{{"tags", 1}, {"_id", -1}}},
+		{Keys: bson.D{{"conversationId", 1}}},
+		{
+			Keys: bson.D{{"authorId", 1}, {"repostOf", 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"repostOf": bson.M{"$exists": true}}),
+		},
+		{Keys: bson.D{{"original.id", 1}}},
+	})
+	return err
 }
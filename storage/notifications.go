@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"mini-twitter/domain/notification"
+	"mini-twitter/stream"
+	"mini-twitter/utils"
+	"sort"
+	"sync"
+)
+
+// NotificationStorage keeps at most one notification per user, type, actor
+// and post, so that adding the same notification again is a no-op. This makes
+// retried tasks and like-unlike-like sequences harmless.
+type NotificationStorage interface {
+	AddNotification(ctx context.Context, n *notification.Notification) error
+	GetNotifications(ctx context.Context, userId string, token string, size int) ([]*notification.Notification, string, error)
+	MarkRead(ctx context.Context, userId string, notificationId string) error
+	MarkAllRead(ctx context.Context, userId string) error
+	GetUnreadCount(ctx context.Context, userId string) (int, error)
+}
+
+type MongoNotificationStorage struct {
+	Notifications *mongo.Collection
+	// Broker, if set, receives the notifications that are actually created.
+	Broker stream.Broker
+	Pages  *PageTokens
+}
+
+func (m *MongoNotificationStorage) AddNotification(ctx context.Context, n *notification.Notification) error {
+	n.CreatedAt = utils.GetCurrentTimestamp()
+	filter := bson.D{{"userId", n.UserId}, {"type", n.Type}, {"actorId", n.ActorId}, {"postId", n.PostId}}
+	update := bson.D{{"$setOnInsert", bson.D{{"read", false}, {"createdAt", n.CreatedAt}}}}
+	updateRes, err := m.Notifications.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil || updateRes.UpsertedID == nil {
+		return err
+	}
+	n.ID, _ = updateRes.UpsertedID.(primitive.ObjectID)
+	n.Read = false
+	publishNotification(ctx, m.Broker, n)
+	return nil
+}
+
+// GetNotifications returns the notifications of the user, newest first.
+func (m *MongoNotificationStorage) GetNotifications(ctx context.Context, userId string, token string, size int) ([]*notification.Notification, string, error) {
+	arr := make([]*notification.Notification, 0)
+	filter := bson.M{"userId": userId}
+	oid, size, err := m.Pages.DecodeOid(notificationsOwner(userId), token, size)
+	if err != nil {
+		return arr, "", err
+	}
+	if !oid.IsZero() {
+		filter["_id"] = bson.M{"$lt": oid}
+	}
+	if size == DEFAULT {
+		size = 10
+	}
+	opt := options.Find()
+	opt.SetSort(bson.D{{"_id", -1}})
+	opt.SetLimit(int64(size) + 1)
+	cur, err := m.Notifications.Find(ctx, filter, opt)
+	if err != nil {
+		return arr, "", err
+	}
+	err = cur.All(ctx, &arr)
+	if err != nil {
+		return arr, "", err
+	}
+	retToken := ""
+	if len(arr) > size {
+		arr = arr[:size]
+		retToken = m.Pages.Encode(notificationsOwner(userId), arr[size-1].ID.Hex(), size)
+	}
+	return arr, retToken, nil
+}
+
+func (m *MongoNotificationStorage) MarkRead(ctx context.Context, userId string, notificationId string) error {
+	oid, err := primitive.ObjectIDFromHex(notificationId)
+	if err != nil {
+		return ErrNotificationNotFound
+	}
+	updateRes, err := m.Notifications.UpdateOne(ctx, bson.M{"_id": oid, "userId": userId}, bson.D{{"$set", bson.D{{"read", true}}}})
+	if err != nil {
+		return err
+	}
+	if updateRes.MatchedCount == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+func (m *MongoNotificationStorage) MarkAllRead(ctx context.Context, userId string) error {
+	_, err := m.Notifications.UpdateMany(ctx, bson.M{"userId": userId, "read": false}, bson.D{{"$set", bson.D{{"read", true}}}})
+	return err
+}
+
+func (m *MongoNotificationStorage) GetUnreadCount(ctx context.Context, userId string) (int, error) {
+	count, err := m.Notifications.CountDocuments(ctx, bson.M{"userId": userId, "read": false})
+	return int(count), err
+}
+
+// EnsureIndexes creates the indexes the queries above rely on.
+func (m *MongoNotificationStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := m.Notifications.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{"userId", 1}, {"type", 1}, {"actorId", 1}, {"postId", 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{"userId", 1}, {"_id", -1}}},
+		{Keys: bson.D{{"userId", 1}, {"read", 1}}},
+	})
+	return err
+}
+
+type InMemoryNotificationStorage struct {
+	mu sync.RWMutex
+	// Notifications of every user, oldest first.
+	Notifications map[string][]*notification.Notification
+	Broker        stream.Broker
+	Pages         *PageTokens
+}
+
+func NewInMemoryNotificationStorage(pages *PageTokens) *InMemoryNotificationStorage {
+	return &InMemoryNotificationStorage{Notifications: make(map[string][]*notification.Notification), Pages: pages}
+}
+
+func (im *InMemoryNotificationStorage) AddNotification(_ context.Context, n *notification.Notification) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for _, existing := range im.Notifications[n.UserId] {
+		if existing.Type == n.Type && existing.ActorId == n.ActorId && existing.PostId == n.PostId {
+			return nil
+		}
+	}
+	n.ID = primitive.NewObjectID()
+	n.Read = false
+	n.CreatedAt = utils.GetCurrentTimestamp()
+	stored := *n
+	im.Notifications[n.UserId] = append(im.Notifications[n.UserId], &stored)
+	publishNotification(context.Background(), im.Broker, &stored)
+	return nil
+}
+
+func (im *InMemoryNotificationStorage) GetNotifications(_ context.Context, userId string, token string, size int) ([]*notification.Notification, string, error) {
+	arr := make([]*notification.Notification, 0)
+	owner := notificationsOwner(userId)
+	oid, size, err := im.Pages.DecodeOid(owner, token, size)
+	if err != nil {
+		return arr, "", err
+	}
+	if size == DEFAULT {
+		size = 10
+	}
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	notifications := im.Notifications[userId]
+	start := len(notifications) - 1
+	if !oid.IsZero() {
+		start = sort.Search(len(notifications), func(i int) bool {
+			return bytes.Compare(notifications[i].ID[:], oid[:]) >= 0
+		}) - 1
+	}
+	for i := start; i >= 0 && len(arr) < size; i-- {
+		n := *notifications[i]
+		arr = append(arr, &n)
+	}
+	retToken := ""
+	if start-size >= 0 {
+		retToken = im.Pages.Encode(owner, arr[len(arr)-1].ID.Hex(), size)
+	}
+	return arr, retToken, nil
+}
+
+func (im *InMemoryNotificationStorage) MarkRead(_ context.Context, userId string, notificationId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for _, n := range im.Notifications[userId] {
+		if n.ID.Hex() == notificationId {
+			n.Read = true
+			return nil
+		}
+	}
+	return ErrNotificationNotFound
+}
+
+func (im *InMemoryNotificationStorage) MarkAllRead(_ context.Context, userId string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for _, n := range im.Notifications[userId] {
+		n.Read = true
+	}
+	return nil
+}
+
+func (im *InMemoryNotificationStorage) GetUnreadCount(_ context.Context, userId string) (int, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	count := 0
+	for _, n := range im.Notifications[userId] {
+		if !n.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func publishNotification(ctx context.Context, broker stream.Broker, n *notification.Notification) {
+	if broker == nil {
+		return
+	}
+	err := broker.Publish(ctx, stream.NewMessage(stream.TypeNotification, n.ID.Hex(), n), stream.NotificationsTopic(n.UserId))
+	if err != nil {
+		log.Printf("failed to publish notification %s: %v", n.ID.Hex(), err)
+	}
+}
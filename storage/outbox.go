@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"mini-twitter/dispatcher"
+	"time"
+)
+
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxFailed  = "failed"
+)
+
+// outboxLease is how long a relay owns a claimed entry. If the relay dies
+// before marking the entry as sent, another one picks it up after the lease.
+const outboxLease = 30 * time.Second
+
+type OutboxEntry struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Name        string             `bson:"name"`
+	Event       bson.Raw           `bson:"event"`
+	Status      string             `bson:"status"`
+	LockedUntil time.Time          `bson:"lockedUntil"`
+	SentAt      *time.Time         `bson:"sentAt,omitempty"`
+}
+
+func newOutboxEntry(e dispatcher.Event) (*OutboxEntry, error) {
+	raw, err := bson.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return &OutboxEntry{Name: e.Name(), Event: raw, Status: outboxPending}, nil
+}
+
+func (oe *OutboxEntry) ToEvent() (dispatcher.Event, error) {
+	var e dispatcher.Event
+	var err error
+	switch oe.Name {
+	case dispatcher.PostCreated{}.Name():
+		var pc dispatcher.PostCreated
+		err = bson.Unmarshal(oe.Event, &pc)
+		e = pc
+	case dispatcher.PostModified{}.Name():
+		var pm dispatcher.PostModified
+		err = bson.Unmarshal(oe.Event, &pm)
+		e = pm
+	case dispatcher.PostDeleted{}.Name():
+		var pd dispatcher.PostDeleted
+		err = bson.Unmarshal(oe.Event, &pd)
+		e = pd
+	case dispatcher.Subscribed{}.Name():
+		var s dispatcher.Subscribed
+		err = bson.Unmarshal(oe.Event, &s)
+		e = s
+	case dispatcher.Unsubscribed{}.Name():
+		var u dispatcher.Unsubscribed
+		err = bson.Unmarshal(oe.Event, &u)
+		e = u
+	case dispatcher.Notified{}.Name():
+		var n dispatcher.Notified
+		err = bson.Unmarshal(oe.Event, &n)
+		e = n
+	default:
+		err = fmt.Errorf("unknown outbox event %q", oe.Name)
+	}
+	return e, err
+}
+
+// OutboxRelay publishes the events that MongoStorage wrote to the outbox
+// collection and marks them as sent. An event is published at least once:
+// if the relay fails between publishing and marking, it is published again.
+type OutboxRelay struct {
+	Outbox     *mongo.Collection
+	Dispatcher dispatcher.FeedDispatcher
+	kick       chan struct{}
+}
+
+func NewOutboxRelay(outbox *mongo.Collection, d dispatcher.FeedDispatcher) *OutboxRelay {
+	return &OutboxRelay{
+		Outbox:     outbox,
+		Dispatcher: d,
+		kick:       make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the relay up without waiting for the next poll.
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (r *OutboxRelay) Run(ctx context.Context) {
+	expireAfter := int32(24 * time.Hour / time.Second)
+	_, err := r.Outbox.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{"status", 1}, {"lockedUntil", 1}, {"_id", 1}}},
+		{Keys: bson.D{{"sentAt", 1}}, Options: options.Index().SetExpireAfterSeconds(expireAfter)},
+	})
+	if err != nil {
+		log.Printf("failed to create outbox indexes: %v", err)
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		r.publishPending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.kick:
+		}
+	}
+}
+
+func (r *OutboxRelay) publishPending(ctx context.Context) {
+	for {
+		entry, err := r.claim(ctx)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Printf("failed to claim outbox entry: %v", err)
+			return
+		}
+		e, err := entry.ToEvent()
+		if err != nil {
+			log.Printf("failed to decode outbox entry %s: %v", entry.ID.Hex(), err)
+			_, _ = r.Outbox.UpdateByID(ctx, entry.ID, bson.D{{"$set", bson.D{{"status", outboxFailed}}}})
+			continue
+		}
+		err = r.Dispatcher.Dispatch(ctx, e)
+		if err != nil {
+			log.Printf("failed to publish outbox entry %s: %v", entry.ID.Hex(), err)
+			return
+		}
+		now := time.Now()
+		update := bson.D{{"$set", bson.D{{"status", outboxSent}, {"sentAt", now}}}}
+		_, err = r.Outbox.UpdateByID(ctx, entry.ID, update)
+		if err != nil {
+			log.Printf("failed to mark outbox entry %s as sent: %v", entry.ID.Hex(), err)
+		}
+	}
+}
+
+func (r *OutboxRelay) claim(ctx context.Context) (*OutboxEntry, error) {
+	now := time.Now()
+	filter := bson.D{{"status", outboxPending}, {"lockedUntil", bson.M{"$lte": now}}}
+	update := bson.D{{"$set", bson.D{{"lockedUntil", now.Add(outboxLease)}}}}
+	opt := options.FindOneAndUpdate()
+	opt.SetSort(bson.D{{"_id", 1}})
+	var entry OutboxEntry
+	err := r.Outbox.FindOneAndUpdate(ctx, filter, update, opt).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
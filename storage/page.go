@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"mini-twitter/domain/feed"
+	"mini-twitter/domain/post"
+)
+
+// Page is a page of a timeline, newest post first.
+type Page struct {
+	Posts []*post.Post `json:"posts"`
+	// Before is the cursor of the posts older than the page. Since is the
+	// cursor of the posts newer than the page, so on the first page it points
+	// at the newest post of the timeline.
+	Before string `json:"before,omitempty"`
+	Since  string `json:"since,omitempty"`
+	// HasMore reports whether the timeline has more posts beyond the page in
+	// the direction it was requested in.
+	HasMore bool `json:"hasMore"`
+}
+
+// cursor is a decoded before or since cursor of a timeline.
+type cursor struct {
+	owner string
+	// key is the sort key of the post the page starts after, or empty for the
+	// newest posts.
+	key string
+	// forward is set for the since cursor. A forward page holds the posts
+	// right after the cursor, so that a client filling a gap skips none.
+	forward bool
+	size    int
+	token   string
+}
+
+// decodeCursor decodes the cursor of a timeline. At most one of before and
+// since may be set.
+func (pt *PageTokens) decodeCursor(owner string, before string, since string, size int) (cursor, error) {
+	c := cursor{owner: owner, forward: since != "", token: since}
+	if before != "" && since != "" {
+		return c, ErrParseToken
+	}
+	if !c.forward {
+		c.token = before
+	}
+	var err error
+	c.key, c.size, err = pt.Decode(owner, c.token, size)
+	if err != nil {
+		return c, err
+	}
+	if c.size == DEFAULT {
+		c.size = 10
+	}
+	return c, nil
+}
+
+// page builds the page of c from posts, newest first, and their sort keys.
+func (pt *PageTokens) page(c cursor, posts []*post.Post, keys []string, hasMore bool) *Page {
+	res := &Page{Posts: posts, HasMore: hasMore}
+	if len(posts) == 0 {
+		// Nothing is newer than since yet, so the client keeps polling with it.
+		if c.forward {
+			res.Since = c.token
+		}
+		return res
+	}
+	res.Since = pt.Encode(c.owner, keys[0], c.size)
+	if c.forward || hasMore {
+		res.Before = pt.Encode(c.owner, keys[len(keys)-1], c.size)
+	}
+	return res
+}
+
+// maxFilteredReads bounds the reads of a list for a single page, so that
+// filters hiding most of a list cannot make one request scan all of it. The
+// page is then shorter than requested, and its cursor continues after the
+// last entry read.
+const maxFilteredReads = 10
+
+// filteredEntries reads the entries of a list from the cursor c on with read,
+// size+1 at a time and in the order of read, leaving out the posts hidden by
+// hide, if any. It returns the entries of the page, whether the list has more
+// of them in the direction of c, and the key the next page continues from.
+func filteredEntries(c cursor, hide func(p *post.Post) (bool, error), read func(key string, size int) ([]feed.Entry, error)) ([]feed.Entry, bool, string, error) {
+	res := make([]feed.Entry, 0, c.size+1)
+	key := c.key
+	for reads := 0; reads < maxFilteredReads; reads++ {
+		entries, err := read(key, c.size+1)
+		if err != nil {
+			return nil, false, "", err
+		}
+		for _, entry := range entries {
+			hidden := false
+			if hide != nil {
+				hidden, err = hide(entry.Post)
+				if err != nil {
+					return nil, false, "", err
+				}
+			}
+			if hidden {
+				key = entry.Oid
+				continue
+			}
+			if len(res) == c.size {
+				return res, true, key, nil
+			}
+			res = append(res, entry)
+			key = entry.Oid
+		}
+		if len(entries) <= c.size {
+			return res, false, key, nil
+		}
+	}
+	return res, true, key, nil
+}
+
+// entriesPage builds the page of c from the entries returned by
+// filteredEntries, which are read oldest first for a forward page.
+func (pt *PageTokens) entriesPage(c cursor, entries []feed.Entry, hasMore bool, last string) *Page {
+	posts := make([]*post.Post, len(entries))
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		j := i
+		if c.forward {
+			j = len(entries) - 1 - i
+		}
+		posts[j] = entry.Post
+		keys[j] = entry.Oid
+	}
+	p := pt.page(c, posts, keys, hasMore)
+	pt.resume(p, c, last)
+	return p
+}
+
+// resume points the cursor of p in the direction of c at key, the last entry
+// read for the page. It differs from the last post of the page when the
+// entries read after that post were filtered out.
+func (pt *PageTokens) resume(p *Page, c cursor, key string) {
+	if key == "" {
+		return
+	}
+	if c.forward {
+		p.Since = pt.Encode(c.owner, key, c.size)
+	} else if p.HasMore {
+		p.Before = pt.Encode(c.owner, key, c.size)
+	}
+}
+
+// olderPage adapts a page to the lists that are only walked backwards, whose
+// next page token is the before cursor.
+func olderPage(p *Page, err error) ([]*post.Post, string, error) {
+	if err != nil {
+		return make([]*post.Post, 0), "", err
+	}
+	return p.Posts, p.Before, nil
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"errors"
+	"mini-twitter/domain/feed"
+	"mini-twitter/domain/post"
+	"strconv"
+	"testing"
+)
+
+// listOf returns a read function over the entries 1..n in order, keyed by
+// their number, and a counter of its calls.
+func listOf(n int) (func(key string, size int) ([]feed.Entry, error), *int) {
+	reads := 0
+	return func(key string, size int) ([]feed.Entry, error) {
+		reads++
+		start := 0
+		if key != "" {
+			start, _ = strconv.Atoi(key)
+		}
+		entries := make([]feed.Entry, 0)
+		for i := start + 1; i <= n && len(entries) < size; i++ {
+			entries = append(entries, feed.Entry{Oid: strconv.Itoa(i), Post: &post.Post{Id: strconv.Itoa(i)}})
+		}
+		return entries, nil
+	}, &reads
+}
+
+func hideIds(hidden func(i int) bool) func(p *post.Post) (bool, error) {
+	return func(p *post.Post) (bool, error) {
+		i, _ := strconv.Atoi(p.Id)
+		return hidden(i), nil
+	}
+}
+
+func TestFilteredEntries(t *testing.T) {
+	errHide := errors.New("hide failed")
+	tests := []struct {
+		name        string
+		n           int
+		key         string
+		hide        func(p *post.Post) (bool, error)
+		wantIds     []string
+		wantHasMore bool
+		wantKey     string
+		wantReads   int
+		wantErr     error
+	}{
+		{name: "no filter, more left", n: 5, wantIds: []string{"1", "2", "3"}, wantHasMore: true, wantKey: "3", wantReads: 1},
+		{name: "no filter, exact page", n: 3, wantIds: []string{"1", "2", "3"}, wantKey: "3", wantReads: 1},
+		{name: "no filter, from key", n: 5, key: "3", wantIds: []string{"4", "5"}, wantKey: "5", wantReads: 1},
+		{name: "empty list", n: 0, wantIds: []string{}, wantReads: 1},
+		{
+			name:        "hidden entries are skipped",
+			n:           10,
+			hide:        hideIds(func(i int) bool { return i%2 == 0 }),
+			wantIds:     []string{"1", "3", "5"},
+			wantHasMore: true,
+			wantKey:     "6",
+			wantReads:   2,
+		},
+		{
+			name:      "hidden tail",
+			n:         8,
+			hide:      hideIds(func(i int) bool { return i > 2 }),
+			wantIds:   []string{"1", "2"},
+			wantKey:   "8",
+			wantReads: 3,
+		},
+		{
+			name:        "reads are bounded",
+			n:           100,
+			hide:        hideIds(func(i int) bool { return true }),
+			wantIds:     []string{},
+			wantHasMore: true,
+			wantKey:     strconv.Itoa(maxFilteredReads * 4),
+			wantReads:   maxFilteredReads,
+		},
+		{
+			name:      "hide error",
+			n:         5,
+			hide:      func(p *post.Post) (bool, error) { return false, errHide },
+			wantErr:   errHide,
+			wantReads: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read, reads := listOf(tt.n)
+			c := cursor{owner: "feed:a", key: tt.key, size: 3}
+			entries, hasMore, key, err := filteredEntries(c, tt.hide, read)
+			if err != tt.wantErr {
+				t.Fatalf("filteredEntries() error = %v, want %v", err, tt.wantErr)
+			}
+			if *reads != tt.wantReads {
+				t.Errorf("filteredEntries() read %d times, want %d", *reads, tt.wantReads)
+			}
+			if err != nil {
+				return
+			}
+			ids := make([]string, 0, len(entries))
+			for _, e := range entries {
+				ids = append(ids, e.Post.Id)
+			}
+			if !equalStrings(ids, tt.wantIds) || hasMore != tt.wantHasMore || key != tt.wantKey {
+				t.Errorf("filteredEntries() = %v, %v, %q, want %v, %v, %q", ids, hasMore, key, tt.wantIds, tt.wantHasMore, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestEntriesPage(t *testing.T) {
+	pt := &PageTokens{Secret: []byte("secret")}
+	entries := []feed.Entry{
+		{Oid: "1", Post: &post.Post{Id: "1"}},
+		{Oid: "2", Post: &post.Post{Id: "2"}},
+	}
+	tests := []struct {
+		name       string
+		forward    bool
+		hasMore    bool
+		last       string
+		wantIds    []string
+		wantSince  string
+		wantBefore string
+	}{
+		{name: "backward", hasMore: true, last: "3", wantIds: []string{"1", "2"}, wantSince: "1", wantBefore: "3"},
+		{name: "backward, last page", last: "2", wantIds: []string{"1", "2"}, wantSince: "1"},
+		{name: "forward", forward: true, last: "4", wantIds: []string{"2", "1"}, wantSince: "4", wantBefore: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cursor{owner: "feed:a", forward: tt.forward, size: 2}
+			p := pt.entriesPage(c, entries, tt.hasMore, tt.last)
+			ids := make([]string, 0, len(p.Posts))
+			for _, item := range p.Posts {
+				ids = append(ids, item.Id)
+			}
+			if !equalStrings(ids, tt.wantIds) {
+				t.Errorf("entriesPage() posts = %v, want %v", ids, tt.wantIds)
+			}
+			since, _, _ := pt.Decode("feed:a", p.Since, DEFAULT)
+			before, _, _ := pt.Decode("feed:a", p.Before, DEFAULT)
+			if since != tt.wantSince || before != tt.wantBefore {
+				t.Errorf("entriesPage() since %q, before %q, want %q, %q", since, before, tt.wantSince, tt.wantBefore)
+			}
+		})
+	}
+}
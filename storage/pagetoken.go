@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+)
+
+const pageTokenVersion = 1
+
+// PageTokens encodes the tokens of the next page. A token is the base64url
+// encoded JSON of pageToken and its HMAC, so that clients can neither read the
+// storage internals from it nor forge it. Owner names the list the token was
+// issued for, so a token of one list is rejected by every other one.
+type PageTokens struct {
+	Secret []byte
+}
+
+type pageToken struct {
+	Version int `json:"v"`
+	// Key is the sort key of the last item of the previous page: the ObjectID
+	// in MongoDB and the insertion order in memory.
+	Key   string `json:"k"`
+	Size  int    `json:"s"`
+	Owner string `json:"o"`
+}
+
+func (pt *PageTokens) Encode(owner string, key string, size int) string {
+	payload, _ := json.Marshal(pageToken{Version: pageTokenVersion, Key: key, Size: size, Owner: owner})
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(pt.sign(payload))
+}
+
+// Decode returns the key of the token, and the size stored in it if size is
+// DEFAULT. An empty token decodes to an empty key.
+func (pt *PageTokens) Decode(owner string, token string, size int) (string, int, error) {
+	if token == "" {
+		return "", size, nil
+	}
+	payloadAndMac := strings.SplitN(token, ".", 2)
+	if len(payloadAndMac) != 2 {
+		return "", size, ErrParseToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadAndMac[0])
+	if err != nil {
+		return "", size, ErrParseToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(payloadAndMac[1])
+	if err != nil || !hmac.Equal(mac, pt.sign(payload)) {
+		return "", size, ErrParseToken
+	}
+	var t pageToken
+	err = json.Unmarshal(payload, &t)
+	if err != nil || t.Version != pageTokenVersion || t.Owner != owner || t.Key == "" {
+		return "", size, ErrParseToken
+	}
+	if size == DEFAULT {
+		size = t.Size
+	}
+	return t.Key, size, nil
+}
+
+// DecodeOid is Decode for lists ordered by ObjectID. An empty token decodes to
+// primitive.NilObjectID.
+func (pt *PageTokens) DecodeOid(owner string, token string, size int) (primitive.ObjectID, int, error) {
+	key, size, err := pt.Decode(owner, token, size)
+	if err != nil {
+		return primitive.NilObjectID, size, err
+	}
+	oid, err := keyOid(key)
+	return oid, size, err
+}
+
+func keyOid(key string) (primitive.ObjectID, error) {
+	if key == "" {
+		return primitive.NilObjectID, nil
+	}
+	oid, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return primitive.NilObjectID, ErrParseToken
+	}
+	return oid, nil
+}
+
+func (pt *PageTokens) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, pt.Secret)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+func postsOwner(userId string) string {
+	return "posts:" + userId
+}
+
+func feedOwner(userId string) string {
+	return "feed:" + userId
+}
+
+func tagOwner(tag string) string {
+	return "tag:" + tag
+}
+
+func repliesOwner(postId string) string {
+	return "replies:" + postId
+}
+
+func likesOwner(postId string) string {
+	return "likes:" + postId
+}
+
+func likedOwner(userId string) string {
+	return "liked:" + userId
+}
+
+func notificationsOwner(userId string) string {
+	return "notifications:" + userId
+}
+
+const deadLettersOwner = "deadletters"
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestPageTokensDecode(t *testing.T) {
+	pt := &PageTokens{Secret: []byte("secret")}
+	token := pt.Encode("feed:a", "42", 20)
+	payload, mac, _ := strings.Cut(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"v":1,"k":"43","s":20,"o":"feed:a"}`))
+	other := &PageTokens{Secret: []byte("other")}
+
+	tests := []struct {
+		name     string
+		owner    string
+		token    string
+		size     int
+		wantKey  string
+		wantSize int
+		wantErr  error
+	}{
+		{name: "empty token", owner: "feed:a", token: "", size: DEFAULT, wantKey: "", wantSize: DEFAULT},
+		{name: "size from token", owner: "feed:a", token: token, size: DEFAULT, wantKey: "42", wantSize: 20},
+		{name: "size from request", owner: "feed:a", token: token, size: 5, wantKey: "42", wantSize: 5},
+		{name: "other owner", owner: "feed:b", token: token, size: DEFAULT, wantErr: ErrParseToken},
+		{name: "no mac", owner: "feed:a", token: payload, size: DEFAULT, wantErr: ErrParseToken},
+		{name: "forged payload", owner: "feed:a", token: forged + "." + mac, size: DEFAULT, wantErr: ErrParseToken},
+		{name: "truncated mac", owner: "feed:a", token: payload + "." + mac[:len(mac)-2], size: DEFAULT, wantErr: ErrParseToken},
+		{name: "invalid base64", owner: "feed:a", token: "!!!." + mac, size: DEFAULT, wantErr: ErrParseToken},
+		{name: "other secret", owner: "feed:a", token: other.Encode("feed:a", "42", 20), size: DEFAULT, wantErr: ErrParseToken},
+		{name: "empty key", owner: "feed:a", token: pt.Encode("feed:a", "", 20), size: DEFAULT, wantErr: ErrParseToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, size, err := pt.Decode(tt.owner, tt.token, tt.size)
+			if err != tt.wantErr {
+				t.Fatalf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if key != tt.wantKey || size != tt.wantSize {
+				t.Errorf("Decode() = %q, %d, want %q, %d", key, size, tt.wantKey, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	pt := &PageTokens{Secret: []byte("secret")}
+	token := pt.Encode("feed:a", "42", 20)
+
+	tests := []struct {
+		name        string
+		before      string
+		since       string
+		size        int
+		wantKey     string
+		wantForward bool
+		wantSize    int
+		wantErr     error
+	}{
+		{name: "first page", size: DEFAULT, wantSize: 10},
+		{name: "before", before: token, size: DEFAULT, wantKey: "42", wantSize: 20},
+		{name: "since", since: token, size: 3, wantKey: "42", wantForward: true, wantSize: 3},
+		{name: "both", before: token, since: token, size: DEFAULT, wantErr: ErrParseToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := pt.decodeCursor("feed:a", tt.before, tt.since, tt.size)
+			if err != tt.wantErr {
+				t.Fatalf("decodeCursor() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if c.key != tt.wantKey || c.forward != tt.wantForward || c.size != tt.wantSize {
+				t.Errorf("decodeCursor() = %+v, want key %q, forward %v, size %d", c, tt.wantKey, tt.wantForward, tt.wantSize)
+			}
+		})
+	}
+}
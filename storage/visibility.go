@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"mini-twitter/domain/post"
+)
+
+// relationship is what the posts of an author a viewer can see depend on.
+type relationship struct {
+	// follows tells whether the viewer follows the author.
+	follows bool
+	// protected tells whether the account of the author is protected.
+	protected bool
+	// blocked tells whether the author blocked the viewer.
+	blocked bool
+	// blocking tells whether the viewer blocked the author.
+	blocking bool
+}
+
+func (r relationship) visible(p *post.Post, viewerId string) bool {
+	return viewerId == p.AuthorId || (!r.blocked && p.VisibleTo(viewerId, r.follows, r.protected))
+}
+
+// listed tells whether p belongs in a list of posts shown to viewerId, which
+// leaves out the posts of the authors the viewer blocked.
+func (r relationship) listed(p *post.Post, viewerId string) bool {
+	return r.visible(p, viewerId) && !r.blocking
+}
+
+// shareable returns ErrForbiddenAccess if userId cannot repost or quote p.
+// Only public posts can be shared, and only by their authors if the account is
+// protected, since the copy embedded into the repost is shown to everybody who
+// can see the repost.
+func (r relationship) shareable(p *post.Post, userId string) error {
+	if !r.visible(p, userId) {
+		return ErrPostNotFound
+	}
+	if !p.Public() || (r.protected && userId != p.AuthorId) {
+		return ErrForbiddenAccess
+	}
+	return nil
+}
+
+// sharedVisible tells whether the viewer can see the reposts and quotes of the
+// posts of the author, which is not the case if the author blocked the viewer.
+func (r relationship) sharedVisible() bool {
+	return !r.blocked
+}
+
+// sharedListed also leaves them out of lists if the viewer blocked the author.
+func (r relationship) sharedListed() bool {
+	return !r.blocked && !r.blocking
+}
+
+func relation(ctx context.Context, s Storage, viewerId string, authorId string) (relationship, error) {
+	var r relationship
+	author, err := s.GetUserById(ctx, authorId)
+	if err != nil && err != ErrUserNotFound {
+		return r, err
+	}
+	r.protected = err == nil && author.Protected
+	if viewerId == "" || viewerId == authorId {
+		return r, nil
+	}
+	subscriptions, err := s.GetSubscriptions(ctx, viewerId)
+	if err != nil {
+		return r, err
+	}
+	r.follows = containsString(subscriptions, authorId)
+	blocked, err := s.GetBlocked(ctx, authorId)
+	if err != nil {
+		return r, err
+	}
+	r.blocked = containsString(blocked, viewerId)
+	blocked, err = s.GetBlocked(ctx, viewerId)
+	if err != nil {
+		return r, err
+	}
+	r.blocking = containsString(blocked, authorId)
+	return r, nil
+}
+
+// viewer looks up the relationships of a viewer with the authors of the posts
+// of a list, each author once.
+type viewer struct {
+	ctx     context.Context
+	s       Storage
+	id      string
+	authors map[string]relationship
+}
+
+func newViewer(ctx context.Context, s Storage, viewerId string) *viewer {
+	return &viewer{ctx: ctx, s: s, id: viewerId, authors: make(map[string]relationship)}
+}
+
+func (v *viewer) relation(authorId string) (relationship, error) {
+	r, ok := v.authors[authorId]
+	if !ok {
+		var err error
+		r, err = relation(v.ctx, v.s, v.id, authorId)
+		if err != nil {
+			return r, err
+		}
+		v.authors[authorId] = r
+	}
+	return r, nil
+}
+
+// hides reports whether p is left out of a list shown to the viewer.
+func (v *viewer) hides(p *post.Post) (bool, error) {
+	r, err := v.relation(p.AuthorId)
+	if err != nil {
+		return false, err
+	}
+	if !r.listed(p, v.id) {
+		return true, nil
+	}
+	return v.hidesOriginal(p)
+}
+
+// hidesOriginal reports whether p reposts or quotes a post of an author the
+// viewer has a block with.
+func (v *viewer) hidesOriginal(p *post.Post) (bool, error) {
+	if p.Original == nil || p.Original.AuthorId == v.id {
+		return false, nil
+	}
+	r, err := v.relation(p.Original.AuthorId)
+	if err != nil {
+		return false, err
+	}
+	return !r.sharedListed(), nil
+}
+
+// MutedIn tells whether p is by one of the muted authors, or reposts or quotes
+// a post of one of them.
+func MutedIn(p *post.Post, muted []string) bool {
+	return containsString(muted, p.AuthorId) || (p.Original != nil && containsString(muted, p.Original.AuthorId))
+}
+
+// checkVisible returns ErrPostNotFound if viewerId cannot see p or the post it
+// reposts or quotes, so that a hidden post cannot be told apart from a missing
+// one.
+func checkVisible(ctx context.Context, s Storage, viewerId string, p *post.Post) error {
+	if viewerId == p.AuthorId {
+		return nil
+	}
+	r, err := relation(ctx, s, viewerId, p.AuthorId)
+	if err != nil {
+		return err
+	}
+	if !r.visible(p, viewerId) {
+		return ErrPostNotFound
+	}
+	if p.Original == nil || p.Original.AuthorId == viewerId {
+		return nil
+	}
+	r, err = relation(ctx, s, viewerId, p.Original.AuthorId)
+	if err != nil {
+		return err
+	}
+	if !r.sharedVisible() {
+		return ErrPostNotFound
+	}
+	return nil
+}
+
+func checkShareable(ctx context.Context, s Storage, userId string, p *post.Post) error {
+	r, err := relation(ctx, s, userId, p.AuthorId)
+	if err != nil {
+		return err
+	}
+	return r.shareable(p, userId)
+}
+
+// mayBeVisible matches the posts of any author that userId may be able to see.
+// The relationship with the author is left to be checked on the posts read.
+func mayBeVisible(userId string) bson.M {
+	return bson.M{"$or": bson.A{bson.M{"authorId": userId}, VisibilityFilter(userId, true)}}
+}
+
+// VisibilityFilter matches the posts of an author that userId can see, given
+// whether the user follows the author. The protected account is not checked.
+func VisibilityFilter(userId string, follows bool) bson.M {
+	visible := bson.A{
+		bson.M{"visibility": bson.M{"$in": bson.A{nil, post.VisibilityPublic}}},
+		bson.M{"visibility": post.VisibilityMentioned, "mentions.userId": userId},
+	}
+	if follows {
+		visible = append(visible, bson.M{"visibility": post.VisibilityFollowers})
+	}
+	return bson.M{"$or": visible}
+}
@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	TypeCreate       = "create"
+	TypeModify       = "modify"
+	TypeNotification = "notification"
+	TypeOnline       = "online"
+	TypeTyping       = "typing"
+)
+
+// Message is published to topics and delivered to their subscribers. The
+// broker fills in Topic on delivery, Id and Data are opaque to it. For feed
+// updates Id is the position of the entry in the feed, the same one that page
+// tokens refer to.
+type Message struct {
+	Topic string          `json:"topic,omitempty"`
+	Type  string          `json:"type"`
+	Id    string          `json:"id,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+func NewMessage(typ string, id string, data any) Message {
+	raw, _ := json.Marshal(data)
+	return Message{Type: typ, Id: id, Data: raw}
+}
+
+// Broker delivers messages to the subscribers of their topics. Delivery is
+// best effort and Publish never waits for subscribers: a subscriber that does
+// not keep up is dropped, that is its channel is closed, and it is expected to
+// catch up from the storage.
+type Broker interface {
+	Publish(ctx context.Context, m Message, topics ...string) error
+	// Subscribe starts a subscription that lasts until ctx is done or the
+	// subscription is closed.
+	Subscribe(ctx context.Context, topics ...string) (Subscription, error)
+}
+
+type Subscription interface {
+	Messages() <-chan Message
+	Subscribe(ctx context.Context, topics ...string) error
+	Unsubscribe(ctx context.Context, topics ...string) error
+	Close() error
+}
+
+// FeedTopic carries the entries added to or changed in the feed of a user.
+func FeedTopic(userId string) string {
+	return "feed:" + userId
+}
+
+// NotificationsTopic carries the new notifications of a user.
+func NotificationsTopic(userId string) string {
+	return "notifications:" + userId
+}
+
+// PresenceTopic carries the presence events of a user.
+func PresenceTopic(userId string) string {
+	return "presence:" + userId
+}
+
+// TypingTopic carries the typing events of the conversations a user takes part
+// in.
+func TypingTopic(userId string) string {
+	return "typing:" + userId
+}
+
+// bufferSize is the number of messages a subscriber may fall behind by before
+// it is dropped.
+const bufferSize = 64
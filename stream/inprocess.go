@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"context"
+	"sync"
+)
+
+// InProcessBroker delivers messages within a single process.
+type InProcessBroker struct {
+	mu     sync.Mutex
+	topics map[string]map[*inProcessSubscription]struct{}
+}
+
+func NewInProcessBroker() *InProcessBroker {
+	return &InProcessBroker{topics: make(map[string]map[*inProcessSubscription]struct{})}
+}
+
+// Publish never blocks, so it can be called while holding storage locks.
+func (b *InProcessBroker) Publish(_ context.Context, m Message, topics ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, topic := range topics {
+		m.Topic = topic
+		for s := range b.topics[topic] {
+			select {
+			case s.ch <- m:
+			default:
+				b.drop(s)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *InProcessBroker) Subscribe(ctx context.Context, topics ...string) (Subscription, error) {
+	s := &inProcessSubscription{
+		broker: b,
+		ch:     make(chan Message, bufferSize),
+		topics: make(map[string]struct{}),
+	}
+	_ = s.Subscribe(ctx, topics...)
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+	return s, nil
+}
+
+// drop closes the subscription unless it is already closed. The caller holds
+// b.mu.
+func (b *InProcessBroker) drop(s *inProcessSubscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for topic := range s.topics {
+		delete(b.topics[topic], s)
+		if len(b.topics[topic]) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+	close(s.ch)
+}
+
+// inProcessSubscription is guarded by the mutex of its broker.
+type inProcessSubscription struct {
+	broker *InProcessBroker
+	ch     chan Message
+	topics map[string]struct{}
+	closed bool
+}
+
+func (s *inProcessSubscription) Messages() <-chan Message {
+	return s.ch
+}
+
+func (s *inProcessSubscription) Subscribe(_ context.Context, topics ...string) error {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	for _, topic := range topics {
+		s.topics[topic] = struct{}{}
+		if b.topics[topic] == nil {
+			b.topics[topic] = make(map[*inProcessSubscription]struct{})
+		}
+		b.topics[topic][s] = struct{}{}
+	}
+	return nil
+}
+
+func (s *inProcessSubscription) Unsubscribe(_ context.Context, topics ...string) error {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, topic := range topics {
+		delete(s.topics, topic)
+		delete(b.topics[topic], s)
+		if len(b.topics[topic]) == 0 {
+			delete(b.topics, topic)
+		}
+	}
+	return nil
+}
+
+func (s *inProcessSubscription) Close() error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s)
+	return nil
+}
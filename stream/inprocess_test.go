@@ -0,0 +1,89 @@
+package stream
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInProcessBrokerRouting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewInProcessBroker()
+	alice, _ := b.Subscribe(ctx, FeedTopic("alice"), NotificationsTopic("alice"))
+	bob, _ := b.Subscribe(ctx, FeedTopic("bob"))
+
+	m := NewMessage(TypeNotification, "1", nil)
+	if err := b.Publish(ctx, m, NotificationsTopic("alice"), FeedTopic("carol")); err != nil {
+		t.Fatal(err)
+	}
+	got := <-alice.Messages()
+	if got.Topic != NotificationsTopic("alice") || got.Type != TypeNotification || got.Id != "1" {
+		t.Errorf("alice got %+v, want %s on %s", got, TypeNotification, NotificationsTopic("alice"))
+	}
+	if len(bob.Messages()) != 0 {
+		t.Errorf("bob got %d messages, want none", len(bob.Messages()))
+	}
+
+	if err := alice.Unsubscribe(ctx, NotificationsTopic("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Subscribe(ctx, NotificationsTopic("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(ctx, m, NotificationsTopic("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if len(alice.Messages()) != 0 {
+		t.Errorf("alice got %d messages after unsubscribing, want none", len(alice.Messages()))
+	}
+	if got := <-bob.Messages(); got.Topic != NotificationsTopic("alice") {
+		t.Errorf("bob got %+v, want a message on %s", got, NotificationsTopic("alice"))
+	}
+}
+
+func TestInProcessBrokerDropsSlowSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewInProcessBroker()
+	slow, _ := b.Subscribe(ctx, FeedTopic("alice"))
+	fast, _ := b.Subscribe(ctx, FeedTopic("alice"))
+
+	for i := 0; i <= bufferSize; i++ {
+		if err := b.Publish(ctx, NewMessage(TypeCreate, "", nil), FeedTopic("alice")); err != nil {
+			t.Fatal(err)
+		}
+		if i < bufferSize {
+			<-fast.Messages()
+		}
+	}
+	received := 0
+	for range slow.Messages() {
+		received++
+	}
+	if received != bufferSize {
+		t.Errorf("slow subscriber got %d messages before being dropped, want %d", received, bufferSize)
+	}
+	if got, ok := <-fast.Messages(); !ok || got.Type != TypeCreate {
+		t.Errorf("fast subscriber got %+v, %v, want the last message", got, ok)
+	}
+	// A dropped subscription ignores later changes and can still be closed.
+	if err := slow.Subscribe(ctx, FeedTopic("bob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := slow.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInProcessBrokerClosesOnDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewInProcessBroker()
+	s, _ := b.Subscribe(ctx, FeedTopic("alice"))
+	cancel()
+	if _, ok := <-s.Messages(); ok {
+		t.Fatal("channel is open after ctx is done")
+	}
+	if err := b.Publish(context.Background(), NewMessage(TypeCreate, "", nil), FeedTopic("alice")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,126 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"sync"
+)
+
+// RedisBroker delivers messages through Redis pub/sub, so that the worker and
+// any number of server replicas can run in separate processes. Every
+// subscription holds a connection to Redis.
+type RedisBroker struct {
+	Client *redis.Client
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, m Message, topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	pipe := b.Client.Pipeline()
+	for _, topic := range topics {
+		pipe.Publish(ctx, channel(topic), msg)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, topics ...string) (Subscription, error) {
+	pubsub := b.Client.Subscribe(ctx, channels(topics)...)
+	if len(topics) != 0 {
+		_, err := pubsub.Receive(ctx)
+		if err != nil {
+			_ = pubsub.Close()
+			return nil, err
+		}
+	}
+	s := &redisSubscription{
+		pubsub: pubsub,
+		ch:     make(chan Message, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go s.receive(ctx)
+	return s, nil
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+	ch     chan Message
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (s *redisSubscription) receive(ctx context.Context) {
+	defer close(s.ch)
+	defer s.pubsub.Close()
+	messages := s.pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var m Message
+			if json.Unmarshal([]byte(msg.Payload), &m) != nil {
+				continue
+			}
+			m.Topic = strings.TrimPrefix(msg.Channel, channelPrefix)
+			select {
+			case s.ch <- m:
+			default:
+				return
+			}
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *redisSubscription) Messages() <-chan Message {
+	return s.ch
+}
+
+func (s *redisSubscription) Subscribe(ctx context.Context, topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	return s.pubsub.Subscribe(ctx, channels(topics)...)
+}
+
+// Unsubscribe with no topics is a no-op rather than unsubscribing from all of
+// them like in Redis.
+func (s *redisSubscription) Unsubscribe(ctx context.Context, topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	return s.pubsub.Unsubscribe(ctx, channels(topics)...)
+}
+
+func (s *redisSubscription) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+const channelPrefix = "stream:"
+
+func channel(topic string) string {
+	return channelPrefix + topic
+}
+
+func channels(topics []string) []string {
+	res := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		res = append(res, channel(topic))
+	}
+	return res
+}
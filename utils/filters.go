@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"errors"
+	"mini-twitter/domain/filter"
+	"mini-twitter/domain/post"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxFilterLength is the maximum length of the value of a filter in characters.
+const MaxFilterLength = 100
+
+// maxFilterInsts bounds the compiled program of a regex filter. Go regexps
+// match in linear time, so the size of the program is what bounds the cost of
+// matching the posts of a feed against the filters.
+const maxFilterInsts = 500
+
+var ErrInvalidFilter = errors.New("invalid filter")
+var ErrFilterTooComplex = errors.New("filter is too complex")
+
+var tagValueRegexp = regexp.MustCompile(`^[\p{L}\p{N}_]+$`)
+
+// NormalizeFilter checks the kind and the value of a filter and brings the
+// value to the form it is matched in.
+func NormalizeFilter(f *filter.Filter) error {
+	f.Value = strings.TrimSpace(f.Value)
+	if f.Kind == filter.KindTag {
+		f.Value = NormalizeTag(f.Value)
+	}
+	if f.Value == "" || utf8.RuneCountInString(f.Value) > MaxFilterLength {
+		return ErrInvalidFilter
+	}
+	switch f.Kind {
+	case filter.KindWord:
+		return nil
+	case filter.KindTag:
+		if !tagValueRegexp.MatchString(f.Value) {
+			return ErrInvalidFilter
+		}
+		return nil
+	case filter.KindRegex:
+		_, err := compileFilter(f)
+		return err
+	}
+	return ErrInvalidFilter
+}
+
+// compileFilter returns the regexp of a word or regex filter.
+func compileFilter(f *filter.Filter) (*regexp.Regexp, error) {
+	if f.Kind == filter.KindWord {
+		// \b only knows ASCII letters, so the word boundaries are spelled out.
+		return regexp.Compile(`(?i)(?:^|[^\p{L}\p{N}_])` + regexp.QuoteMeta(f.Value) + `(?:[^\p{L}\p{N}_]|$)`)
+	}
+	re, err := syntax.Parse(f.Value, syntax.Perl|syntax.FoldCase)
+	if err != nil {
+		return nil, ErrInvalidFilter
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, ErrInvalidFilter
+	}
+	if len(prog.Inst) > maxFilterInsts {
+		return nil, ErrFilterTooComplex
+	}
+	res, err := regexp.Compile("(?i)" + f.Value)
+	if err != nil {
+		return nil, ErrInvalidFilter
+	}
+	return res, nil
+}
+
+// FeedFilter hides the posts matched by any of the filters of a user.
+type FeedFilter struct {
+	texts []*regexp.Regexp
+	tags  map[string]bool
+}
+
+// NewFeedFilter returns nil if there are no filters. The filters are expected
+// to be normalized, the ones that fail to compile are skipped.
+func NewFeedFilter(filters []*filter.Filter) *FeedFilter {
+	if len(filters) == 0 {
+		return nil
+	}
+	ff := &FeedFilter{tags: make(map[string]bool)}
+	for _, f := range filters {
+		if f.Kind == filter.KindTag {
+			ff.tags[f.Value] = true
+			continue
+		}
+		re, err := compileFilter(f)
+		if err == nil {
+			ff.texts = append(ff.texts, re)
+		}
+	}
+	return ff
+}
+
+// Hides reports whether the post or the post it reposts or quotes is matched
+// by a filter. A nil FeedFilter hides nothing.
+func (ff *FeedFilter) Hides(p *post.Post) bool {
+	if ff == nil || p == nil {
+		return false
+	}
+	for _, tag := range p.Tags {
+		if ff.tags[tag] {
+			return true
+		}
+	}
+	for _, re := range ff.texts {
+		if re.MatchString(p.Text) {
+			return true
+		}
+	}
+	return ff.Hides(p.Original)
+}
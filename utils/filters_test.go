@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"mini-twitter/domain/filter"
+	"mini-twitter/domain/post"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		kind      string
+		value     string
+		wantValue string
+		wantErr   error
+	}{
+		{name: "word", kind: filter.KindWord, value: "  spoiler ", wantValue: "spoiler"},
+		{name: "phrase", kind: filter.KindWord, value: "game of thrones", wantValue: "game of thrones"},
+		{name: "tag", kind: filter.KindTag, value: "#GoLang", wantValue: "golang"},
+		{name: "tag with spaces", kind: filter.KindTag, value: "#go lang", wantErr: ErrInvalidFilter},
+		{name: "regex", kind: filter.KindRegex, value: `foo\d+`, wantValue: `foo\d+`},
+		{name: "invalid regex", kind: filter.KindRegex, value: `foo(`, wantErr: ErrInvalidFilter},
+		{name: "repeat too large", kind: filter.KindRegex, value: `(a{1,1000}){1,1000}`, wantErr: ErrInvalidFilter},
+		{name: "large regex", kind: filter.KindRegex, value: `a{1,99}b{1,99}c{1,99}d{1,99}e{1,99}f{1,99}`, wantErr: ErrFilterTooComplex},
+		{name: "empty", kind: filter.KindWord, value: "   ", wantErr: ErrInvalidFilter},
+		{name: "too long", kind: filter.KindWord, value: strings.Repeat("я", MaxFilterLength+1), wantErr: ErrInvalidFilter},
+		{name: "longest", kind: filter.KindWord, value: strings.Repeat("я", MaxFilterLength), wantValue: strings.Repeat("я", MaxFilterLength)},
+		{name: "unknown kind", kind: "user", value: "spoiler", wantErr: ErrInvalidFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &filter.Filter{Kind: tt.kind, Value: tt.value}
+			err := NormalizeFilter(f)
+			if err != tt.wantErr {
+				t.Fatalf("NormalizeFilter() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && f.Value != tt.wantValue {
+				t.Errorf("NormalizeFilter() value = %q, want %q", f.Value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestFeedFilterHides(t *testing.T) {
+	filters := []*filter.Filter{
+		{Kind: filter.KindWord, Value: "spoiler"},
+		{Kind: filter.KindWord, Value: "ёлка"},
+		{Kind: filter.KindTag, Value: "nsfw"},
+		{Kind: filter.KindRegex, Value: `^buy now`},
+		{Kind: filter.KindRegex, Value: `foo(`},
+	}
+	tests := []struct {
+		name    string
+		filters []*filter.Filter
+		post    *post.Post
+		want    bool
+	}{
+		{name: "word", filters: filters, post: &post.Post{Text: "No SPOILER, please"}, want: true},
+		{name: "part of a word", filters: filters, post: &post.Post{Text: "spoilers ahead"}, want: false},
+		{name: "cyrillic word", filters: filters, post: &post.Post{Text: "Наряжаем Ёлка!"}, want: true},
+		{name: "part of a cyrillic word", filters: filters, post: &post.Post{Text: "ёлками"}, want: false},
+		{name: "tag", filters: filters, post: &post.Post{Text: "#NSFW", Tags: []string{"nsfw"}}, want: true},
+		{name: "tag text without tag", filters: filters, post: &post.Post{Text: "nsfw"}, want: false},
+		{name: "regex", filters: filters, post: &post.Post{Text: "Buy now!"}, want: true},
+		{name: "regex not anchored", filters: filters, post: &post.Post{Text: "Do not buy now"}, want: false},
+		{name: "repost", filters: filters, post: &post.Post{Original: &post.Post{Text: "a spoiler"}}, want: true},
+		{name: "quote", filters: filters, post: &post.Post{Text: "look", Original: &post.Post{Tags: []string{"nsfw"}}}, want: true},
+		{name: "clean", filters: filters, post: &post.Post{Text: "hello", Original: &post.Post{Text: "world"}}, want: false},
+		{name: "no filters", post: &post.Post{Text: "spoiler"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewFeedFilter(tt.filters).Hides(tt.post); got != tt.want {
+				t.Errorf("Hides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"mini-twitter/domain/post"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// A mention starts with '@' that is not glued to a preceding word.
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([A-Za-z0-9_]+)`)
+var userIdRegexp = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// ExtractMentions returns every "@handle" and "@userId" of text. Only one of
+// Handle and UserId is set, the other one is up to the caller to resolve.
+func ExtractMentions(text string) []post.Mention {
+	mentions := make([]post.Mention, 0)
+	for _, idx := range mentionRegexp.FindAllStringSubmatchIndex(text, -1) {
+		name := text[idx[2]:idx[3]]
+		mention := post.Mention{
+			Start: utf8.RuneCountInString(text[:idx[2]-1]),
+			End:   utf8.RuneCountInString(text[:idx[3]]),
+		}
+		if userIdRegexp.MatchString(name) {
+			mention.UserId = name
+		} else if len(name) <= 15 {
+			mention.Handle = strings.ToLower(name)
+		} else {
+			continue
+		}
+		mentions = append(mentions, mention)
+	}
+	return mentions
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"mini-twitter/domain/post"
+	"reflect"
+	"testing"
+)
+
+func TestExtractMentions(t *testing.T) {
+	userId := "0123456789abcdef01234567"
+	tests := []struct {
+		name string
+		text string
+		want []post.Mention
+	}{
+		{name: "none", text: "hello world", want: []post.Mention{}},
+		{name: "handle", text: "hi @Alice!", want: []post.Mention{{Handle: "alice", Start: 3, End: 9}}},
+		{name: "user id", text: "@" + userId, want: []post.Mention{{UserId: userId, Start: 0, End: 25}}},
+		{name: "offsets in characters", text: "привет @bob", want: []post.Mention{{Handle: "bob", Start: 7, End: 11}}},
+		{name: "several", text: "@a @b", want: []post.Mention{{Handle: "a", Start: 0, End: 2}, {Handle: "b", Start: 3, End: 5}}},
+		{name: "email", text: "mail me at bob@example.com", want: []post.Mention{}},
+		{name: "double at", text: "@@bob", want: []post.Mention{}},
+		{name: "handle too long", text: "@abcdefghijklmnop", want: []post.Mention{}},
+		{name: "longest handle", text: "@abcdefghijklmno", want: []post.Mention{{Handle: "abcdefghijklmno", Start: 0, End: 16}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractMentions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
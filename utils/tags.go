@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A tag starts with '#' that is not glued to a preceding word.
+var tagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_#])#([\p{L}\p{N}_]+)`)
+
+// ExtractTags returns the distinct hashtags of text in the order they first
+// appear, normalized with NormalizeTag.
+func ExtractTags(text string) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, match := range tagRegexp.FindAllStringSubmatch(text, -1) {
+		tag := NormalizeTag(match[1])
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
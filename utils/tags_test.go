@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractTags(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "none", text: "hello world", want: []string{}},
+		{name: "one", text: "learning #Go", want: []string{"go"}},
+		{name: "distinct in order", text: "#b #a #B", want: []string{"b", "a"}},
+		{name: "unicode", text: "#Привет, мир", want: []string{"привет"}},
+		{name: "underscore and digits", text: "#go_1_19!", want: []string{"go_1_19"}},
+		{name: "glued to a word", text: "C#sharp", want: []string{}},
+		{name: "double hash", text: "##go", want: []string{}},
+		{name: "hash alone", text: "# go", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractTags(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,691 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"mini-twitter/dispatcher"
+	"mini-twitter/domain/blocks"
+	"mini-twitter/domain/deadletter"
+	"mini-twitter/domain/notification"
+	"mini-twitter/domain/post"
+	"mini-twitter/domain/subscribers"
+	"mini-twitter/domain/subscriptions"
+	"mini-twitter/storage"
+	"mini-twitter/stream"
+	"os"
+	"strconv"
+)
+
+// Worker holds everything the feed tasks need. One Worker is shared by all
+// machinery goroutines, so the handlers never take locks: every write is an
+// idempotent upsert or delete, and races between tasks are resolved by
+// re-reading the source of truth from the database.
+type Worker struct {
+	Posts         *mongo.Collection
+	Feed          *mongo.Collection
+	Subscribers   *mongo.Collection
+	Subscriptions *mongo.Collection
+	Likes         *mongo.Collection
+	Blocks        *mongo.Collection
+	DeadLetters   storage.DeadLetterStorage
+	Notifications storage.NotificationStorage
+	// Cache is set when the server runs with the Redis cache layer, so that
+	// the worker can drop cached feed pages it has just made stale.
+	Cache *storage.CachedStorage
+	// Broker receives the feed rows written by the worker, so that the
+	// servers can push them to connected clients.
+	Broker    stream.Broker
+	BatchSize int
+	// CelebrityThreshold is the number of followers above which posts are
+	// not fanned out on write but read live by MongoStorage.GetFeed.
+	CelebrityThreshold int
+}
+
+func NewWorker(client *mongo.Client, dbName string, cache *storage.CachedStorage, broker stream.Broker) *Worker {
+	db := client.Database(dbName)
+	return &Worker{
+		Posts:         db.Collection("posts"),
+		Feed:          db.Collection("feed"),
+		Subscribers:   db.Collection("subscribers"),
+		Subscriptions: db.Collection("subscriptions"),
+		Likes:         db.Collection("likes"),
+		Blocks:        db.Collection("blocks"),
+		DeadLetters:   &storage.MongoDeadLetterStorage{DeadLetters: db.Collection("deadletters")},
+		Notifications: &storage.MongoNotificationStorage{Notifications: db.Collection("notifications"), Broker: broker},
+		Cache:         cache,
+		Broker:        broker,
+		BatchSize:     intFromEnv("FANOUT_BATCH_SIZE", 500),
+
+		CelebrityThreshold: intFromEnv("CELEBRITY_THRESHOLD", 10000),
+	}
+}
+
+// Tasks returns the machinery tasks served by the worker.
+func (w *Worker) Tasks() map[string]interface{} {
+	return map[string]interface{}{
+		"create":      w.ProcessNewPost,
+		"modify":      w.ProcessModifyPost,
+		"subscribe":   w.ProcessSubscribe,
+		"unsubscribe": w.ProcessUnsubscribe,
+		"delete":      w.ProcessDeletePost,
+		"notify":      w.ProcessNotify,
+
+		dispatcher.DeadLetterTask: w.ProcessDeadLetter,
+	}
+}
+
+// HandleEvent serves events of the in-process dispatcher.
+func (w *Worker) HandleEvent(_ context.Context, e dispatcher.Event) error {
+	switch e := e.(type) {
+	case dispatcher.PostCreated:
+		return w.ProcessNewPost(e.Post.Id, e.Post.AuthorId, e.Post.Text, e.Post.CreatedAt, e.Post.LastModifiedAt, e.Oid)
+	case dispatcher.PostModified:
+		return w.ProcessModifyPost(e.Post.Id, e.Post.AuthorId, e.Post.Text, e.Post.CreatedAt, e.Post.LastModifiedAt, e.Oid)
+	case dispatcher.PostDeleted:
+		return w.ProcessDeletePost(e.PostId, e.AuthorId, e.Oid)
+	case dispatcher.Subscribed:
+		return w.ProcessSubscribe(e.Subscribee, e.Subscriber)
+	case dispatcher.Unsubscribed:
+		return w.ProcessUnsubscribe(e.Subscribee, e.Subscriber)
+	case dispatcher.Notified:
+		return w.ProcessNotify(e.UserId, e.Type, e.ActorId, e.PostId)
+	}
+	return nil
+}
+
+// DeadLetterEvent stores an event of the in-process dispatcher that failed all
+// of its retries.
+func (w *Worker) DeadLetterEvent(e dispatcher.Event, err error) {
+	args, argsErr := dispatcher.EventArgs(e)
+	if argsErr == nil {
+		argsErr = w.DeadLetters.AddDeadLetter(context.Background(), &deadletter.DeadLetter{Name: e.Name(), Args: args, Error: err.Error()})
+	}
+	if argsErr != nil {
+		log.Printf("failed to store %s event in dead letters: %v", e.Name(), argsErr)
+	}
+}
+
+func (w *Worker) ProcessSubscribe(subscribee, subscriber string) error {
+	ctx := context.Background()
+	var s subscriptions.Subscriptions
+	err := w.Subscriptions.FindOne(ctx, bson.D{{"user", subscriber}}).Decode(&s)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	// Posts marked with onRead are merged into the feed at read time. Replies
+	// are only copied if the subscriber follows the replied-to author too.
+	filter := bson.D{
+		{"authorId", subscribee},
+		{"onRead", bson.M{"$ne": true}},
+		{"$and", bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"inReplyToAuthorId": bson.M{"$exists": false}},
+				bson.M{"inReplyToAuthorId": bson.M{"$in": append(s.Subscriptions, subscribee, subscriber)}},
+			}},
+			storage.VisibilityFilter(subscriber, true),
+		}},
+	}
+	cur, err := w.Posts.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	models := make([]mongo.WriteModel, 0, w.BatchSize)
+	for cur.Next(ctx) {
+		var p post.PostWithOID
+		err = cur.Decode(&p)
+		if err != nil {
+			return err
+		}
+		models = append(models, feedUpsert(subscriber, &p))
+		if len(models) == w.BatchSize {
+			_, err = w.Feed.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+			if err != nil {
+				return err
+			}
+			models = models[:0]
+		}
+	}
+	if err = cur.Err(); err != nil {
+		return err
+	}
+	if len(models) != 0 {
+		_, err = w.Feed.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+	}
+
+	// An unsubscribe may have been processed while we were backfilling, in
+	// which case the rows written above must not stay in the feed.
+	err = w.Subscribers.FindOne(ctx, bson.D{{"user", subscribee}, {"subscribers", subscriber}}).Err()
+	if err == mongo.ErrNoDocuments {
+		return w.ProcessUnsubscribe(subscribee, subscriber)
+	}
+	if err != nil {
+		return err
+	}
+	w.invalidateFeed(ctx, subscriber)
+	return nil
+}
+
+func (w *Worker) ProcessUnsubscribe(subscribee, subscriber string) error {
+	ctx := context.Background()
+	// Replies of other authors to the subscribee go away together with the
+	// subscribee's own posts.
+	filter := bson.D{{"userId", subscriber}, {"$or", bson.A{
+		bson.M{"authorId": subscribee},
+		bson.M{"inReplyToAuthorId": subscribee, "authorId": bson.M{"$ne": subscriber}},
+	}}}
+	_, err := w.Feed.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+	w.invalidateFeed(ctx, subscriber)
+	return nil
+}
+
+func (w *Worker) ProcessModifyPost(Id, AuthorId, Text, CreatedAt, LastModifiedAt, Oid string) error {
+	ctx := context.Background()
+	_oid, err := primitive.ObjectIDFromHex(Oid)
+	if err != nil {
+		return err
+	}
+	// Copy the current version of the post rather than the one from the
+	// event, so that edits processed out of order cannot roll the text back.
+	var p post.PostWithOID
+	err = w.Posts.FindOne(ctx, bson.D{{"_id", _oid}}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{{"oid", _oid}}
+	userIds, err := w.Feed.Distinct(ctx, "userId", filter)
+	if err != nil {
+		return err
+	}
+	update := bson.D{{"$set", bson.D{
+		{"text", p.Text},
+		{"tags", p.Tags},
+		{"mentions", p.Mentions},
+		{"lastModifiedAt", p.LastModifiedAt},
+	}}}
+	_, err = w.Feed.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	w.invalidateFeed(ctx, toStrings(userIds)...)
+	if p.OnRead {
+		// The post has no feed rows, so its recipients are found the same
+		// way as when it was created.
+		var s subscribers.Subscribers
+		err = w.Subscribers.FindOne(ctx, bson.D{{"user", p.AuthorId}}).Decode(&s)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		recipients, err := w.recipients(ctx, &p, s.Subscribers)
+		if err != nil {
+			return err
+		}
+		w.publishInBatches(ctx, stream.TypeModify, &p, recipients)
+	} else {
+		w.publish(ctx, stream.TypeModify, &p, toStrings(userIds))
+	}
+
+	update = bson.D{{"$set", bson.D{
+		{"original.text", p.Text},
+		{"original.tags", p.Tags},
+		{"original.mentions", p.Mentions},
+		{"original.lastModifiedAt", p.LastModifiedAt},
+	}}}
+	return w.updateEmbeddedCopies(ctx, p.Id, update)
+}
+
+func (w *Worker) ProcessDeletePost(Id, AuthorId, Oid string) error {
+	ctx := context.Background()
+	_oid, err := primitive.ObjectIDFromHex(Oid)
+	if err != nil {
+		return err
+	}
+	filter := bson.D{{"oid", _oid}}
+	userIds, err := w.Feed.Distinct(ctx, "userId", filter)
+	if err != nil {
+		return err
+	}
+	_, err = w.Feed.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+	w.invalidateFeed(ctx, toStrings(userIds)...)
+
+	_, err = w.Likes.DeleteMany(ctx, bson.D{{"postId", Id}})
+	if err != nil {
+		return err
+	}
+	// Reposts go away together with the post, quotes stay without the copy.
+	err = w.deleteReposts(ctx, Id)
+	if err != nil {
+		return err
+	}
+	return w.updateEmbeddedCopies(ctx, Id, bson.D{{"$unset", bson.D{{"original", ""}}}})
+}
+
+func (w *Worker) ProcessNewPost(Id, AuthorId, Text, CreatedAt, LastModifiedAt, Oid string) error {
+	ctx := context.Background()
+	var s subscribers.Subscribers
+	err := w.Subscribers.FindOne(ctx, bson.D{{"user", AuthorId}}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_oid, err := primitive.ObjectIDFromHex(Oid)
+	if err != nil {
+		return err
+	}
+	var p post.PostWithOID
+	err = w.Posts.FindOne(ctx, bson.D{{"_id", _oid}}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		// The post was deleted before it reached the feeds.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	celebrity := len(s.Subscribers) > w.CelebrityThreshold
+	if celebrity {
+		// The cached feeds are not invalidated for such posts, they expire
+		// on their own after a short time instead.
+		_, err = w.Posts.UpdateByID(ctx, _oid, bson.D{{"$set", bson.D{{"onRead", true}}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	recipients, err := w.recipients(ctx, &p, s.Subscribers)
+	if err != nil {
+		return err
+	}
+	if celebrity {
+		// The feeds read the post live, but connected followers still have
+		// to be told about it.
+		w.publishInBatches(ctx, stream.TypeCreate, &p, recipients)
+		return nil
+	}
+
+	failed := 0
+	opts := options.BulkWrite().SetOrdered(false)
+	for start := 0; start < len(recipients); start += w.BatchSize {
+		end := start + w.BatchSize
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+		batch := recipients[start:end]
+		if p.RepostOf != "" {
+			batch, err = w.withoutOriginal(ctx, p.RepostOf, batch)
+			if err != nil {
+				log.Printf("fan-out of post %s: batch of subscribers [%d, %d) failed: %v", Id, start, end, err)
+				failed++
+				continue
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		models := make([]mongo.WriteModel, 0, len(batch))
+		for _, elem := range batch {
+			models = append(models, feedUpsert(elem, &p))
+		}
+		_, err = w.Feed.BulkWrite(ctx, models, opts)
+		if err != nil {
+			// Upserts are idempotent, so the whole task can be retried and
+			// the batches that succeeded are simply written again.
+			log.Printf("fan-out of post %s: batch of subscribers [%d, %d) failed: %v", Id, start, end, err)
+			failed++
+			continue
+		}
+		w.invalidateFeed(ctx, batch...)
+		w.publish(ctx, stream.TypeCreate, &p, batch)
+	}
+	if failed != 0 {
+		return fmt.Errorf("fan-out of post %s: %d of %d batches failed", Id, failed, (len(recipients)+w.BatchSize-1)/w.BatchSize)
+	}
+
+	// A delete may have been processed during the fan-out, in which case the
+	// rows written above would resurrect the post.
+	err = w.Posts.FindOne(ctx, bson.D{{"_id", _oid}}).Err()
+	if err == mongo.ErrNoDocuments {
+		return w.ProcessDeletePost(Id, AuthorId, Oid)
+	}
+	return err
+}
+
+// ProcessNotify creates a notification, unless it is about a post that has
+// been deleted in the meantime or one of the users blocked the other.
+func (w *Worker) ProcessNotify(UserId, Type, ActorId, PostId string) error {
+	ctx := context.Background()
+	blocked, err := w.blockedBetween(ctx, UserId, ActorId)
+	if err != nil || blocked {
+		return err
+	}
+	if PostId != "" {
+		err := w.Posts.FindOne(ctx, bson.D{{"id", PostId}}).Err()
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Notifications.AddNotification(ctx, &notification.Notification{
+		UserId:  UserId,
+		Type:    Type,
+		ActorId: ActorId,
+		PostId:  PostId,
+	})
+}
+
+// ProcessDeadLetter stores a task that failed all of its retries, so that it
+// can be inspected and replayed through the admin API.
+func (w *Worker) ProcessDeadLetter(taskErr, name, rawArgs string) error {
+	var args []string
+	err := json.Unmarshal([]byte(rawArgs), &args)
+	if err != nil {
+		return err
+	}
+	return w.DeadLetters.AddDeadLetter(context.Background(), &deadletter.DeadLetter{Name: name, Args: args, Error: taskErr})
+}
+
+func (w *Worker) invalidateFeed(ctx context.Context, userIds ...string) {
+	if w.Cache != nil {
+		w.Cache.InvalidateFeed(ctx, userIds...)
+	}
+}
+
+// publish pushes feed rows to the connected clients. Clients that miss an
+// update catch up from the feed, so errors are only logged.
+func (w *Worker) publish(ctx context.Context, typ string, p *post.PostWithOID, userIds []string) {
+	if w.Broker == nil || len(userIds) == 0 {
+		return
+	}
+	topics := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		topics = append(topics, stream.FeedTopic(userId))
+	}
+	err := w.Broker.Publish(ctx, stream.NewMessage(typ, p.ID.Hex(), p.ToPost()), topics...)
+	if err != nil {
+		log.Printf("failed to publish %s of post %s: %v", typ, p.Id, err)
+	}
+}
+
+func (w *Worker) publishInBatches(ctx context.Context, typ string, p *post.PostWithOID, userIds []string) {
+	for start := 0; start < len(userIds); start += w.BatchSize {
+		end := start + w.BatchSize
+		if end > len(userIds) {
+			end = len(userIds)
+		}
+		w.publish(ctx, typ, p, userIds[start:end])
+	}
+}
+
+// recipients returns the followers of the author who get the post in their
+// feeds and streams.
+func (w *Worker) recipients(ctx context.Context, p *post.PostWithOID, followers []string) ([]string, error) {
+	var replied subscribers.Subscribers
+	if p.InReplyToAuthorId != "" && p.InReplyToAuthorId != p.AuthorId {
+		err := w.Subscribers.FindOne(ctx, bson.D{{"user", p.InReplyToAuthorId}}).Decode(&replied)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+	authorIds := []string{p.AuthorId}
+	if p.Original != nil {
+		authorIds = append(authorIds, p.Original.AuthorId)
+	}
+	blocked, err := w.blockedWith(ctx, authorIds, followers)
+	if err != nil {
+		return nil, err
+	}
+	return feedRecipients(p, followers, replied.Subscribers, blocked), nil
+}
+
+// feedRecipients returns the followers who get the post. Posts visible to the
+// mentioned users only go to the mentioned followers, and replies to the ones
+// who follow the replied-to author too or are that author. Nobody in blocked
+// gets the post.
+func feedRecipients(p *post.PostWithOID, followers []string, repliedFollowers []string, blocked map[string]bool) []string {
+	reply := p.InReplyToAuthorId != "" && p.InReplyToAuthorId != p.AuthorId
+	replied := make(map[string]bool, len(repliedFollowers)+1)
+	for _, userId := range repliedFollowers {
+		replied[userId] = true
+	}
+	replied[p.InReplyToAuthorId] = true
+	res := make([]string, 0, len(followers))
+	for _, userId := range followers {
+		if blocked[userId] {
+			continue
+		}
+		if p.Visibility == post.VisibilityMentioned && !mentions(p, userId) {
+			continue
+		}
+		if reply && !replied[userId] {
+			continue
+		}
+		res = append(res, userId)
+	}
+	return res
+}
+
+func mentions(p *post.PostWithOID, userId string) bool {
+	for _, mention := range p.Mentions {
+		if mention.UserId == userId {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedBetween reports whether either of the users blocked the other.
+func (w *Worker) blockedBetween(ctx context.Context, userId string, otherId string) (bool, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"user": userId, "blocked": otherId},
+		bson.M{"user": otherId, "blocked": userId},
+	}}
+	count, err := w.Blocks.CountDocuments(ctx, filter)
+	return count != 0, err
+}
+
+// blockedWith returns the users among userIds who blocked one of the authors
+// or were blocked by one of them. A block removes the subscriptions, but the
+// post may have been queued before it.
+func (w *Worker) blockedWith(ctx context.Context, authorIds []string, userIds []string) (map[string]bool, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"user": bson.M{"$in": authorIds}},
+		bson.M{"user": bson.M{"$in": userIds}, "blocked": bson.M{"$in": authorIds}},
+	}}
+	cur, err := w.Blocks.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var lists []blocks.Blocks
+	err = cur.All(ctx, &lists)
+	if err != nil {
+		return nil, err
+	}
+	blocked := make(map[string]bool)
+	for _, b := range lists {
+		if containsString(authorIds, b.UserId) {
+			for _, userId := range b.Blocked {
+				blocked[userId] = true
+			}
+		}
+		for _, authorId := range authorIds {
+			if containsString(b.Blocked, authorId) {
+				blocked[b.UserId] = true
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// withoutOriginal drops the users who already have the reposted post or another
+// repost of it in their feeds.
+func (w *Worker) withoutOriginal(ctx context.Context, originalId string, userIds []string) ([]string, error) {
+	filter := bson.D{
+		{"userId", bson.M{"$in": userIds}},
+		{"$or", bson.A{bson.M{"id": originalId}, bson.M{"repostOf": originalId}}},
+	}
+	found, err := w.Feed.Distinct(ctx, "userId", filter)
+	if err != nil {
+		return nil, err
+	}
+	has := make(map[string]bool, len(found))
+	for _, userId := range toStrings(found) {
+		has[userId] = true
+	}
+	res := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		if !has[userId] {
+			res = append(res, userId)
+		}
+	}
+	return res, nil
+}
+
+// updateEmbeddedCopies applies update to the reposts and quotes of a post,
+// both in the posts and in the feed collection.
+func (w *Worker) updateEmbeddedCopies(ctx context.Context, postId string, update bson.D) error {
+	filter := bson.D{{"original.id", postId}}
+	copies, err := w.findForCache(ctx, filter)
+	if err != nil {
+		return err
+	}
+	_, err = w.Posts.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	userIds, err := w.Feed.Distinct(ctx, "userId", filter)
+	if err != nil {
+		return err
+	}
+	_, err = w.Feed.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	w.invalidateFeed(ctx, toStrings(userIds)...)
+	w.invalidatePosts(ctx, copies)
+	return nil
+}
+
+func (w *Worker) deleteReposts(ctx context.Context, postId string) error {
+	filter := bson.D{{"repostOf", postId}}
+	reposts, err := w.findForCache(ctx, filter)
+	if err != nil {
+		return err
+	}
+	_, err = w.Posts.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+	userIds, err := w.Feed.Distinct(ctx, "userId", filter)
+	if err != nil {
+		return err
+	}
+	_, err = w.Feed.DeleteMany(ctx, filter)
+	if err != nil {
+		return err
+	}
+	w.invalidateFeed(ctx, toStrings(userIds)...)
+	w.invalidatePosts(ctx, reposts)
+	return nil
+}
+
+// findForCache returns the ids and authors of the posts matching filter, which
+// is all that is needed to drop them from the cache.
+func (w *Worker) findForCache(ctx context.Context, filter bson.D) ([]*post.Post, error) {
+	if w.Cache == nil {
+		return nil, nil
+	}
+	opt := options.Find().SetProjection(bson.D{{"id", 1}, {"authorId", 1}})
+	cur, err := w.Posts.Find(ctx, filter, opt)
+	if err != nil {
+		return nil, err
+	}
+	posts := make([]*post.Post, 0)
+	err = cur.All(ctx, &posts)
+	return posts, err
+}
+
+func (w *Worker) invalidatePosts(ctx context.Context, posts []*post.Post) {
+	if w.Cache != nil {
+		w.Cache.InvalidatePosts(ctx, posts...)
+	}
+}
+
+// feedUpsert puts a copy of the post into the feed of the given user.
+func feedUpsert(userId string, p *post.PostWithOID) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.D{
+			{"userId", userId},
+			{"oid", p.ID},
+		}).
+		SetUpdate(bson.D{
+			{"$set",
+				bson.D{
+					{"id", p.Id},
+					{"text", p.Text},
+					{"lastModifiedAt", p.LastModifiedAt},
+					{"authorId", p.AuthorId},
+					{"createdAt", p.CreatedAt},
+					{"inReplyTo", p.InReplyTo},
+					{"inReplyToAuthorId", p.InReplyToAuthorId},
+					{"conversationId", p.ConversationId},
+					{"repostOf", p.RepostOf},
+					{"quoteOf", p.QuoteOf},
+					{"original", p.Original},
+					{"tags", p.Tags},
+					{"mentions", p.Mentions},
+					{"visibility", p.Visibility},
+				},
+			},
+		}).
+		SetUpsert(true)
+}
+
+func intFromEnv(name string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+func toStrings(values []interface{}) []string {
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		res = append(res, v.(string))
+	}
+	return res
+}
+
+func containsString(arr []string, s string) bool {
+	for _, elem := range arr {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
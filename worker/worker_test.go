@@ -0,0 +1,66 @@
+package worker
+
+import (
+	"mini-twitter/domain/post"
+	"reflect"
+	"testing"
+)
+
+func TestFeedRecipients(t *testing.T) {
+	followers := []string{"bob", "carol", "dave"}
+	tests := []struct {
+		name             string
+		post             *post.PostWithOID
+		repliedFollowers []string
+		blocked          map[string]bool
+		want             []string
+	}{
+		{name: "public", post: &post.PostWithOID{AuthorId: "alice"}, want: []string{"bob", "carol", "dave"}},
+		{
+			name: "mentioned",
+			post: &post.PostWithOID{
+				AuthorId:   "alice",
+				Visibility: post.VisibilityMentioned,
+				Mentions:   []post.Mention{{UserId: "carol"}, {UserId: "erin"}},
+			},
+			want: []string{"carol"},
+		},
+		{
+			name:             "reply",
+			post:             &post.PostWithOID{AuthorId: "alice", InReplyToAuthorId: "erin"},
+			repliedFollowers: []string{"dave"},
+			want:             []string{"dave"},
+		},
+		{
+			name: "reply to a follower",
+			post: &post.PostWithOID{AuthorId: "alice", InReplyToAuthorId: "bob"},
+			want: []string{"bob"},
+		},
+		{
+			name: "reply to self",
+			post: &post.PostWithOID{AuthorId: "alice", InReplyToAuthorId: "alice"},
+			want: []string{"bob", "carol", "dave"},
+		},
+		{
+			name:    "blocked",
+			post:    &post.PostWithOID{AuthorId: "alice"},
+			blocked: map[string]bool{"carol": true},
+			want:    []string{"bob", "dave"},
+		},
+		{
+			name:             "blocked reply",
+			post:             &post.PostWithOID{AuthorId: "alice", InReplyToAuthorId: "erin"},
+			repliedFollowers: []string{"bob", "dave"},
+			blocked:          map[string]bool{"dave": true},
+			want:             []string{"bob"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := feedRecipients(tt.post, followers, tt.repliedFollowers, tt.blocked)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("feedRecipients() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}